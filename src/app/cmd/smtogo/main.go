@@ -1,22 +1,436 @@
 package main
 
 import (
-	"log"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hnrobert/smtogo/internal/api"
+	"github.com/hnrobert/smtogo/internal/archive"
+	"github.com/hnrobert/smtogo/internal/auth"
 	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/janitor"
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/ratelimit"
+	"github.com/hnrobert/smtogo/internal/store"
+	"github.com/hnrobert/smtogo/internal/tracing"
+	"github.com/hnrobert/smtogo/internal/version"
 )
 
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// options holds the command-line flags
+type options struct {
+	configPath string
+	listen     string
+	dataDir    string
+
+	// args are the arguments after the flags, for commands that take them
+	args []string
+}
+
+// commandsWithArgs take arguments after their flags
+var commandsWithArgs = map[string]bool{"decrypt": true}
+
 func main() {
+	command, args := splitCommand(os.Args[1:])
+
+	opts, err := parseFlags(command, args, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	switch command {
+	case "serve":
+		serve(opts)
+	case "version":
+		fmt.Println(version.Version)
+	case "print-config":
+		if err := printConfig(opts, os.Stdout); err != nil {
+			fatal("Failed to print configuration", err)
+		}
+	case "migrate-results":
+		if err := migrateResults(opts, os.Stdout); err != nil {
+			fatal("Failed to migrate email results", err)
+		}
+	case "decrypt":
+		if err := decryptRecords(opts, os.Stdout); err != nil {
+			fatal("Failed to decrypt records", err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
+		os.Exit(2)
+	}
+}
+
+// splitCommand separates the optional leading command from its flags
+func splitCommand(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "serve", args
+}
+
+// serve runs the API server until it fails
+func serve(opts *options) {
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		fatal("Refusing to start", err)
+	}
+	logging.Configure(cfg.Log)
+
+	// Export spans when tracing is configured, flushing them on exit
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Refusing to start", err)
+	}
+
+	// Load the keys that encrypt records in the data directory
+	keys, err := encryption.New(cfg.Encryption)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Refusing to start", err)
+	}
+
+	// Open the store for keys managed through the admin API, hashed with
+	// the configured pepper or one generated in the data directory
+	pepper := cfg.APIKeyPepper
+	if pepper == "" {
+		if pepper, err = auth.LoadPepper(cfg.KeyStorePepperPath()); err != nil {
+			fatal("Refusing to start", err)
+		}
+	}
+	keyStore, err := auth.OpenKeyStore(cfg.KeyStorePath(), pepper)
+	if err != nil {
+		fatal("Refusing to start", err)
+	}
+
+	// Open the per-key send counts
+	quota, err := ratelimit.OpenQuotaTracker(cfg.QuotaUsagePath())
+	if err != nil {
+		fatal("Refusing to start", err)
+	}
+	go quota.Run(context.Background(), ratelimit.FlushInterval)
+	go flushOnSignal(shutdownTracing, quota)
+
+	// Open the email result store
+	results, err := store.Open(cfg, keys)
+	if err != nil {
+		fatal("Refusing to start", err)
 	}
 
 	// Start the API server
-	server := api.NewServer(cfg)
+	slog.Info("Starting smtogo", "version", version.Version)
+	server := api.NewServer(cfg, api.WithKeyStore(keyStore), api.WithQuotaTracker(quota), api.WithResultStore(results), api.WithKeyring(keys))
+	jan := janitor.New(cfg, results, keys)
+	go jan.Run(context.Background())
+	go watchConfig(context.Background(), opts, server, jan.SetConfig)
 	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		shutdownTracing(context.Background())
+		quota.Flush()
+		fatal("Failed to start server", err)
+	}
+}
+
+// tracingFlushTimeout limits how long exiting waits for spans to be exported
+const tracingFlushTimeout = 5 * time.Second
+
+// flushOnSignal exports pending spans, saves quota counts and exits on
+// SIGINT or SIGTERM
+func flushOnSignal(shutdown func(context.Context) error, quota *ratelimit.QuotaTracker) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("Failed to flush spans", "error", err)
+	}
+	if err := quota.Flush(); err != nil {
+		slog.Error("Failed to save quota usage", "error", err)
+	}
+	slog.Info("Shutting down", "signal", sig.String())
+	os.Exit(0)
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// printConfig writes the effective configuration, after merging the file,
+// environment and flags, as JSON with secrets masked
+func printConfig(opts *options, w io.Writer) error {
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(cfg.Masked())
+}
+
+// migrateResults imports the JSON result files in the data directory into
+// the SQLite result database, which can then be selected with
+// storage.backend
+func migrateResults(opts *options, w io.Writer) error {
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	keys, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	db, err := store.OpenSQLiteStore(cfg.ResultDBPath(), keys)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, skipped, err := store.ImportFiles(context.Background(), cfg.DataDir, db)
+	for _, file := range skipped {
+		fmt.Fprintf(w, "Skipped %s: %v\n", file.Path, file.Err)
+	}
+	fmt.Fprintf(w, "Imported %d email results from %s into %s\n", count, cfg.DataDir, cfg.ResultDBPath())
+	if err == nil && len(skipped) > 0 {
+		err = fmt.Errorf("%d result files could not be imported", len(skipped))
+	}
+	return err
+}
+
+// decryptRecords writes the plaintext of each argument to w. An argument is
+// either a file in the data directory, such as a result, debug dump or
+// archive bundle, or the ID of an email in the result store or archive,
+// which is written as JSON. Bundles are decompressed as well.
+func decryptRecords(opts *options, w io.Writer) error {
+	if len(opts.args) == 0 {
+		return errors.New("no files or email IDs given")
+	}
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	keys, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var results store.Store
+	for _, target := range opts.args {
+		if _, err := os.Stat(target); err == nil {
+			if err := decryptFile(target, keys, w); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Only open the store once an email ID is looked up
+		if results == nil {
+			if results, err = store.Open(cfg, keys); err != nil {
+				return err
+			}
+			defer results.Close()
+		}
+		result, err := results.Get(ctx, target)
+		if errors.Is(err, store.ErrNotFound) {
+			result, err = archive.Extract(ctx, cfg.ArchiveDir(), keys, target, "")
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%s is neither a file nor a stored email ID", target)
+		}
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptFile writes the plaintext of one file to w, decompressing gzip
+// content such as archive bundles
+func decryptFile(path string, keys *encryption.Keyring, w io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = keys.Open(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		if _, err := io.Copy(w, zr); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// gzipMagic starts gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// parseFlags parses the command-line arguments of command. The config path
+// falls back to the SMTOGO_CONFIG environment variable when the flag is
+// not given.
+func parseFlags(command string, args []string, output io.Writer) (*options, error) {
+	opts := &options{}
+
+	fs := flag.NewFlagSet("smtogo", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.configPath, "config", os.Getenv(config.EnvPrefix+"CONFIG"),
+		"path to the config file (env "+config.EnvPrefix+"CONFIG, default "+config.DefaultPath+")")
+	fs.StringVar(&opts.listen, "listen", "",
+		"address to listen on, e.g. :8000 or unix:/run/smtogo.sock (env "+config.EnvPrefix+"LISTEN)")
+	fs.StringVar(&opts.dataDir, "data-dir", "",
+		"directory for email results (env "+config.EnvPrefix+"DATA_DIR, default data)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: smtogo [command] [flags] [arguments]
+
+Commands:
+  serve            run the API server (default)
+  print-config     print the effective configuration with secrets masked
+  migrate-results  import JSON result files into the SQLite result database
+  decrypt          print records decrypted; arguments are files in the data
+                   directory or email IDs
+  version          print the build version
+
+Flags:
+`)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), `
+Configuration precedence (highest first):
+  1. command-line flags
+  2. environment variables (%s<FIELD>, e.g. %sSMTP_PORT)
+  3. config file
+  4. built-in defaults
+
+The default config file is optional; a path given with -config or
+%sCONFIG must exist. The format is chosen by extension: .json, .jsonc,
+.yaml, .yml or .toml.
+`, config.EnvPrefix, config.EnvPrefix, config.EnvPrefix)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.args = fs.Args()
+	if fs.NArg() > 0 && !commandsWithArgs[command] {
+		fmt.Fprintf(fs.Output(), "unexpected argument: %s\n", fs.Arg(0))
+		fs.Usage()
+		return nil, fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	return opts, nil
+}
+
+// loadConfig loads the configuration, applies the flags and validates it
+func loadConfig(opts *options) (*config.Config, error) {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	opts.apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// watchConfig reloads the configuration on SIGHUP or when the config file
+// changes. An invalid configuration is logged and the running one is kept.
+func watchConfig(ctx context.Context, opts *options, server *api.Server, also ...func(*config.Config)) {
+	trigger := make(chan string, 1)
+	notify := func(reason string) {
+		select {
+		case trigger <- reason:
+		default:
+			// A reload is already pending
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			notify("SIGHUP")
+		}
+	}()
+
+	path := opts.configPath
+	if path == "" {
+		path = config.DefaultPath
+	}
+	go config.Watch(ctx, path, configPollInterval, func() { notify("file change") })
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reason := <-trigger:
+			if err := reloadConfig(opts, server, also...); err != nil {
+				slog.Error("Config reload rejected, keeping current config", "reason", reason, "error", err)
+				continue
+			}
+			slog.Info("Config reloaded", "reason", reason)
+		}
+	}
+}
+
+// reloadConfig loads a new configuration and swaps it into the server and
+// any other background components
+func reloadConfig(opts *options, server *api.Server, also ...func(*config.Config)) error {
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	logging.Configure(cfg.Log)
+	server.Reload(cfg)
+	for _, apply := range also {
+		apply(cfg)
+	}
+	return nil
+}
+
+// apply overrides config values with the flags that were set
+func (o *options) apply(cfg *config.Config) {
+	if o.listen != "" {
+		cfg.Listen = o.listen
+	}
+	if o.dataDir != "" {
+		cfg.DataDir = o.dataDir
 	}
 }
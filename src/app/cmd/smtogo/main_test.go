@@ -1,14 +1,50 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/hnrobert/smtogo/internal/api"
+	"github.com/hnrobert/smtogo/internal/archive"
+	"github.com/hnrobert/smtogo/internal/auth"
 	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
 
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -37,6 +73,69 @@ func TestHealthEndpoint(t *testing.T) {
 
 	// Check response body contains expected content
 	assert.Contains(t, rr.Body.String(), "status")
+	assert.Contains(t, rr.Body.String(), `"version":"dev"`)
+}
+
+func TestReadinessChecks(t *testing.T) {
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		Health:      config.Health{SMTPCheck: config.SMTPCheckConnect, Timeout: 1},
+	}
+	server := api.NewServer(cfg)
+	router := server.GetRouter()
+
+	type check struct {
+		Status string `json:"status"`
+		Detail string `json:"detail"`
+	}
+	get := func(path string) (int, string, map[string]check) {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var body struct {
+			Status string           `json:"status"`
+			Checks map[string]check `json:"checks"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return rr.Code, body.Status, body.Checks
+	}
+
+	// Test liveness does not depend on the relay
+	code, status, _ := get("/health/live")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alive", status)
+
+	// Test an unreachable relay makes the service not ready
+	code, status, checks := get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not ready", status)
+	assert.Equal(t, "fail", checks["smtp:127.0.0.1:1"].Status)
+	assert.NotEmpty(t, checks["smtp:127.0.0.1:1"].Detail)
+	assert.Equal(t, "ok", checks["data_dir"].Status)
+	assert.Equal(t, "ok", checks["queue"].Status)
+
+	// Test the service is ready with the SMTP check disabled
+	cfg2 := *cfg
+	cfg2.Health.SMTPCheck = config.SMTPCheckOff
+	server.Reload(&cfg2)
+	code, status, checks = get("/health/ready")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", status)
+	assert.NotContains(t, checks, "smtp:127.0.0.1:1")
+
+	// Test an unwritable data directory is reported
+	cfg3 := cfg2
+	cfg3.DataDir = filepath.Join(dataDir, "file")
+	assert.NoError(t, os.WriteFile(cfg3.DataDir, nil, 0644))
+	server.Reload(&cfg3)
+	code, _, checks = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", checks["data_dir"].Status)
+	assert.Contains(t, checks["data_dir"].Detail, "data directory is not writable")
 }
 
 func TestOpenAPIEndpoint(t *testing.T) {
@@ -71,3 +170,1120 @@ func TestOpenAPIEndpoint(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "openapi")
 	assert.Contains(t, rr.Body.String(), "Test SMTP API")
 }
+
+func TestParseFlags(t *testing.T) {
+	t.Setenv("SMTOGO_CONFIG", "/etc/smtogo/env.jsonc")
+
+	// Test config path falls back to the environment
+	opts, err := parseFlags("serve", []string{"--listen", "127.0.0.1:9000", "--data-dir", "/var/lib/smtogo"}, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/smtogo/env.jsonc", opts.configPath)
+
+	// Test flags override config values
+	cfg := &config.Config{Listen: ":8000", DataDir: "data"}
+	opts.apply(cfg)
+	assert.Equal(t, "127.0.0.1:9000", cfg.Listen)
+	assert.Equal(t, "/var/lib/smtogo", cfg.DataDir)
+
+	// Test config flag overrides the environment
+	opts, err = parseFlags("serve", []string{"--config", "/tmp/flag.jsonc"}, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/flag.jsonc", opts.configPath)
+
+	// Test unexpected arguments are rejected
+	_, err = parseFlags("serve", []string{"extra"}, io.Discard)
+	assert.Error(t, err)
+
+	// Test commands that take arguments keep them
+	opts, err = parseFlags("decrypt", []string{"--config", "x.yaml", "a.json", "e1"}, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.json", "e1"}, opts.args)
+}
+
+func TestHelpDocumentsPrecedence(t *testing.T) {
+	var out bytes.Buffer
+	_, err := parseFlags("serve", []string{"--help"}, &out)
+	assert.Equal(t, flag.ErrHelp, err)
+	assert.Contains(t, out.String(), "precedence")
+	assert.Contains(t, out.String(), "-data-dir")
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp_config.jsonc")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write(`{"smtp_server": "smtp.example.com", "smtp_port": 25, "sender_email": "a@example.com"}`)
+
+	opts := &options{configPath: path}
+	cfg, err := loadConfig(opts)
+	assert.NoError(t, err)
+	server := api.NewServer(cfg)
+	router := server.GetRouter()
+
+	send := func(key string) int {
+		req, _ := http.NewRequest("POST", "/v1/mail/send", strings.NewReader(`{}`))
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, send(""))
+
+	// Test a valid config enables the API key without a restart
+	write(`{"smtp_server": "smtp.example.com", "smtp_port": 25, "sender_email": "a@example.com", "api_key": "new-key"}`)
+	assert.NoError(t, reloadConfig(opts, server))
+	assert.Equal(t, http.StatusForbidden, send(""))
+	assert.Equal(t, http.StatusBadRequest, send("new-key"))
+
+	// Test an invalid config is rejected and the running one kept
+	write(`{"smtp_server": "", "smtp_port": 25, "sender_email": "a@example.com", "api_key": "other-key"}`)
+	assert.Error(t, reloadConfig(opts, server))
+	assert.Equal(t, http.StatusBadRequest, send("new-key"))
+}
+
+func TestPrintConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp_config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+smtp_server: smtp.example.com
+smtp_port: 587
+use_tls: true
+use_password: true
+sender_email: a@example.com
+sender_password: hunter2
+`), 0644))
+
+	var out bytes.Buffer
+	assert.NoError(t, printConfig(&options{configPath: path, listen: ":9000"}, &out))
+	assert.Contains(t, out.String(), `"smtp_server": "smtp.example.com"`)
+	assert.Contains(t, out.String(), `"listen": ":9000"`)
+	assert.Contains(t, out.String(), `"sender_password": "********"`)
+	assert.NotContains(t, out.String(), "hunter2")
+}
+
+func TestMigrateResults(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "smtp_config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+smtp_server: smtp.example.com
+smtp_port: 587
+sender_email: a@example.com
+storage:
+  backend: sqlite
+`), 0644))
+
+	files := store.OpenFileStore(dataDir, nil)
+	assert.NoError(t, files.Save(context.Background(), &models.EmailResult{
+		EmailID: "e1", Status: "success", Recipient: "to@example.com", Timestamp: time.Now().Format(time.RFC3339),
+	}))
+
+	var out bytes.Buffer
+	assert.NoError(t, migrateResults(&options{configPath: path, dataDir: dataDir}, &out))
+	assert.Contains(t, out.String(), "Imported 1 email results")
+
+	db, err := store.OpenSQLiteStore(filepath.Join(dataDir, "emails.db"), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	result, err := db.Get(context.Background(), "e1")
+	assert.NoError(t, err)
+	assert.Equal(t, "to@example.com", result.Recipient)
+}
+
+func TestDecryptRecords(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "smtp_config.yaml")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	assert.NoError(t, os.WriteFile(path, []byte(`
+smtp_server: smtp.example.com
+smtp_port: 587
+sender_email: a@example.com
+storage:
+  backend: sqlite
+encryption:
+  keys:
+    - id: k1
+      key: env:TEST_ENCRYPTION_KEY
+`), 0644))
+	t.Setenv("TEST_ENCRYPTION_KEY", key)
+	opts := &options{configPath: path, dataDir: dataDir}
+
+	cfg, err := loadConfig(opts)
+	assert.NoError(t, err)
+	keys, err := encryption.New(cfg.Encryption)
+	assert.NoError(t, err)
+	results, err := store.Open(cfg, keys)
+	assert.NoError(t, err)
+	day := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	for _, id := range []string{"stored", "archived"} {
+		assert.NoError(t, results.Save(ctx, &models.EmailResult{
+			EmailID: id, Status: "success", Recipient: id + "@example.com", Timestamp: day.Format(time.RFC3339),
+		}))
+	}
+	_, err = archive.Days(ctx, results, cfg.ArchiveDir(), keys, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.NoError(t, results.Save(ctx, &models.EmailResult{
+		EmailID: "stored", Status: "success", Recipient: "stored@example.com", Timestamp: day.Format(time.RFC3339),
+	}))
+	results.Close()
+
+	// Test email IDs are found in the store or the archive
+	var out bytes.Buffer
+	opts.args = []string{"stored", "archived"}
+	assert.NoError(t, decryptRecords(opts, &out))
+	assert.Contains(t, out.String(), `"recipient": "stored@example.com"`)
+	assert.Contains(t, out.String(), `"recipient": "archived@example.com"`)
+
+	// Test bundles are decrypted and decompressed
+	bundle := filepath.Join(cfg.ArchiveDir(), "2024-05-10.jsonl.gz")
+	sealed, err := os.ReadFile(bundle)
+	assert.NoError(t, err)
+	assert.True(t, encryption.IsSealed(sealed))
+	out.Reset()
+	opts.args = []string{bundle}
+	assert.NoError(t, decryptRecords(opts, &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"email_id":"archived"`)
+
+	opts.args = []string{"missing"}
+	assert.ErrorContains(t, decryptRecords(opts, io.Discard), "missing is neither a file nor a stored email ID")
+	opts.args = nil
+	assert.Error(t, decryptRecords(opts, io.Discard))
+}
+
+func TestSplitCommand(t *testing.T) {
+	command, args := splitCommand([]string{"--listen", ":9000"})
+	assert.Equal(t, "serve", command)
+	assert.Equal(t, []string{"--listen", ":9000"}, args)
+
+	command, args = splitCommand([]string{"print-config", "--config", "x.yaml"})
+	assert.Equal(t, "print-config", command)
+	assert.Equal(t, []string{"--config", "x.yaml"}, args)
+}
+
+func TestSendEmailSenderIdentity(t *testing.T) {
+	cfg := &config.Config{
+		APIKey:        "test-key",
+		SMTPServer:    "127.0.0.1",
+		SMTPPort:      1,
+		SenderEmail:   "noreply@example.com",
+		Senders:       []config.SenderIdentity{{ID: "billing", Email: "billing@example.com"}},
+		APIKeySenders: []string{"default"},
+		DataDir:       t.TempDir(),
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/mail/send", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "test-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	const base = `"recipient_email": "to@example.com", "subject": "Hi", "body": "Hello", "body_type": "plain"`
+
+	// Test an unknown identity is rejected
+	rr := send(`{` + base + `, "sender_id": "marketing"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unknown sender_id")
+
+	// Test mismatched from and sender_id are rejected
+	rr = send(`{` + base + `, "from": "billing@example.com", "sender_id": "default"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Test the key may not use an identity outside its allowed list
+	rr = send(`{` + base + `, "from": "billing@example.com"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "billing")
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "sender", Key: "send-key", Scopes: []string{config.ScopeSend}},
+			{Name: "reader", Key: "read-key", Scopes: []string{config.ScopeReadStatus}},
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin}},
+		},
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	// Store a result sent by the reader key
+	emailID := "123e4567-e89b-12d3-a456-426614174000"
+	resultDir := filepath.Join(dataDir, "2026-01-01", "success")
+	assert.NoError(t, os.MkdirAll(resultDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(resultDir, emailID+".json"),
+		[]byte(`{"email_id": "`+emailID+`", "status": "success", "api_key_name": "reader"}`), 0644))
+
+	get := func(key, id string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/mail/status/"+id, nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test a key without the scope is rejected
+	assert.Equal(t, http.StatusForbidden, get("send-key", emailID).Code)
+	assert.Equal(t, http.StatusForbidden, get("wrong-key", emailID).Code)
+
+	// Test the owning key and admin can read the result
+	rr := get("read-key", emailID)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"api_key_name":"reader"`)
+	assert.Equal(t, http.StatusOK, get("admin-key", emailID).Code)
+
+	// Test invalid and unknown IDs
+	assert.Equal(t, http.StatusBadRequest, get("read-key", "not-a-uuid").Code)
+	assert.Equal(t, http.StatusNotFound, get("read-key", "00000000-0000-0000-0000-000000000000").Code)
+}
+
+func TestAdminAPIKeys(t *testing.T) {
+	cfg := &config.Config{
+		SenderEmail: "noreply@example.com",
+		DataDir:     t.TempDir(),
+		APIKeys: []config.APIKey{
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin}},
+			{Name: "sender", Key: "send-key", Scopes: []string{config.ScopeSend}},
+		},
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test only admin keys reach the admin API
+	assert.Equal(t, http.StatusForbidden, do("GET", "/v1/admin/keys", "send-key", "").Code)
+
+	// Test creation returns the secret once
+	rr := do("POST", "/v1/admin/keys", "admin-key", `{"name": "reporting", "scopes": ["read-status"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Key)
+
+	rr = do("GET", "/v1/admin/keys", "admin-key", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"reporting"`)
+	assert.NotContains(t, rr.Body.String(), created.Key)
+	assert.NotContains(t, rr.Body.String(), "admin-key")
+
+	// Test duplicate names, reserved names, unknown scopes and bad IP
+	// ranges are rejected
+	assert.Equal(t, http.StatusConflict, do("POST", "/v1/admin/keys", "admin-key", `{"name": "sender", "scopes": ["send"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/v1/admin/keys", "admin-key", `{"name": "jwt:x", "scopes": ["send"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/v1/admin/keys", "admin-key", `{"name": "x", "scopes": ["root"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/v1/admin/keys", "admin-key", `{"name": "x", "scopes": ["send"], "allowed_ips": ["10.0.0.0/33"]}`).Code)
+
+	// Test a managed key is limited to its IP ranges
+	rr = do("POST", "/v1/admin/keys", "admin-key", `{"name": "internal", "scopes": ["read-status"], "allowed_ips": ["10.0.0.0/8"], "daily_quota": 5}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"daily_quota":5`)
+	var internal struct {
+		Key string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &internal))
+	rr = do("GET", "/v1/mail/status/00000000-0000-0000-0000-000000000000", internal.Key, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "client IP is not allowed")
+
+	// Test the new key authenticates, and stops after revocation
+	status := "/v1/mail/status/00000000-0000-0000-0000-000000000000"
+	assert.Equal(t, http.StatusNotFound, do("GET", status, created.Key, "").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/v1/admin/keys/"+created.ID, "admin-key", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", status, created.Key, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/v1/admin/keys/unknown/rotate", "admin-key", "").Code)
+
+	// Test a revoked key's name cannot be reused
+	rr = do("POST", "/v1/admin/keys", "admin-key", `{"name": "reporting", "scopes": ["read-status"]}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "already exists")
+}
+
+func TestAdminAPIRequiresAuthentication(t *testing.T) {
+	cfg := &config.Config{SenderEmail: "noreply@example.com"}
+	router := api.NewServer(cfg).GetRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/admin/keys", strings.NewReader(`{"name": "x", "scopes": ["admin"]}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestRateLimitAndQuota(t *testing.T) {
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "reader", Key: "read-key", Scopes: []string{config.ScopeReadStatus}, RateLimitPerMinute: 1},
+			{Name: "sender", Key: "send-key", Scopes: []string{config.ScopeSend}, DailyQuota: 1},
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin}},
+		},
+		RateLimit: config.RateLimit{PerKeyPerMinute: 100, DailyQuota: 50},
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test the per-key rate limit and its headers
+	rr := do("GET", "/v1/mail/usage", "read-key", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	rr = do("GET", "/v1/mail/usage", "read-key", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// Test the daily quota
+	body := `{"recipient_email": "to@example.com", "subject": "Hi", "body": "Hello", "body_type": "plain"}`
+	rr = do("POST", "/v1/mail/send", "send-key", body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var queued struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	rr = do("POST", "/v1/mail/send", "send-key", body)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), "daily send quota exceeded")
+
+	rr = do("GET", "/v1/mail/usage", "send-key", "")
+	assert.Contains(t, rr.Body.String(), `"daily":{"used":1,"limit":1`)
+
+	// Test the admin usage report shows a managed key's own limits
+	rr = do("POST", "/v1/admin/keys", "admin-key", `{"name": "batch", "scopes": ["send"], "rate_limit_per_minute": 7, "daily_quota": 3, "monthly_quota": 40}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	rr = do("GET", "/v1/admin/usage", "admin-key", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `{"name":"batch","limits":{"per_minute":7,"daily":3,"monthly":40}`)
+
+	// Wait for the background send to record its result before the data
+	// directory is removed
+	waitForResult(t, dataDir, queued.EmailID)
+}
+
+// waitForResult waits until the result file of an email has been written
+func waitForResult(t *testing.T, dataDir, emailID string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if matches, _ := filepath.Glob(filepath.Join(dataDir, "*", "*", emailID+".json")); len(matches) > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no result was written for email %s", emailID)
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	var logs syncBuffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(config.Logging{Level: "info", Format: "json"}, &logs))
+
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin, config.ScopeSend}},
+		},
+		Redaction: config.Redaction{
+			DenyHeaders: []string{"x-forwarded-for"},
+			Recipient:   config.RedactMask,
+			Subject:     config.RedactMask,
+		},
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "admin-key")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("Cookie", "session=abc")
+		req.Header.Set("User-Agent", "billing/1.0")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/v1/mail/send", `{"recipient_email": "john.doe@example.com", "subject": "Invoice 42 overdue", "body": "Hello", "body_type": "plain"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var queued struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	waitForResult(t, dataDir, queued.EmailID)
+
+	// Test the stored result holds masked values and only allowed headers
+	rr = do("GET", "/v1/mail/status/"+queued.EmailID, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var stored models.EmailResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stored))
+	assert.Equal(t, "j***@example.com", stored.Recipient)
+	assert.Equal(t, "Inv***", stored.Subject)
+	assert.Equal(t, "billing/1.0", stored.Headers["User-Agent"])
+	assert.NotContains(t, stored.Headers, "X-Forwarded-For")
+	assert.NotContains(t, stored.Headers, "Cookie")
+
+	// Test the full address still finds the result
+	rr = do("GET", "/v1/admin/emails?recipient=john.doe@example.com", "")
+	assert.Contains(t, rr.Body.String(), queued.EmailID)
+
+	// Test logs carry the masked values only
+	assert.Contains(t, logs.String(), `"recipient":"j***@example.com"`)
+	assert.Contains(t, logs.String(), `"subject":"Inv***"`)
+	assert.NotContains(t, logs.String(), "john.doe")
+	assert.NotContains(t, logs.String(), "Invoice")
+}
+
+func TestSignedRequest(t *testing.T) {
+	cfg := &config.Config{
+		SenderEmail:      "noreply@example.com",
+		DataDir:          t.TempDir(),
+		SignatureMaxSkew: 300,
+		APIKeys: []config.APIKey{
+			{Name: "reader", Key: "read-secret", Scopes: []string{config.ScopeReadStatus}, RequireSignature: true},
+		},
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	path := "/v1/mail/status/00000000-0000-0000-0000-000000000000"
+	signedGet := func(nonce string) *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		digest := auth.BodyDigest(nil)
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set(auth.HeaderKeyID, "reader")
+		req.Header.Set(auth.HeaderTimestamp, timestamp)
+		req.Header.Set(auth.HeaderNonce, nonce)
+		req.Header.Set(auth.HeaderContentSHA256, digest)
+		req.Header.Set(auth.HeaderSignature, auth.Sign("read-secret", auth.StringToSign("GET", path, timestamp, nonce, digest)))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test a signed request authenticates and cannot be replayed
+	assert.Equal(t, http.StatusNotFound, signedGet("nonce-1").Code)
+	rr := signedGet("nonce-1")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "nonce")
+
+	// Test the key cannot be used as a static header
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("X-API-Key", "read-secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "requires signed requests")
+
+	// Test only an oversized body is reported as too large
+	signedPost := func(body io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/mail/send", body)
+		req.Header.Set(auth.HeaderKeyID, "reader")
+		req.Header.Set(auth.HeaderSignature, "unused")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = signedPost(strings.NewReader(strings.Repeat("a", 10<<20+1)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	rr = signedPost(io.MultiReader(strings.NewReader("{"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "failed to read request body")
+}
+
+func TestBearerToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	assert.NoError(t, os.WriteFile(jwksPath, jwks, 0644))
+
+	// A result sent with the API key named like the token subject
+	dataDir := t.TempDir()
+	results := store.OpenFileStore(dataDir, nil)
+	keyResult := "11111111-1111-1111-1111-111111111111"
+	assert.NoError(t, results.Save(context.Background(), &models.EmailResult{
+		EmailID: keyResult, Status: "success", APIKeyName: "reporting", Timestamp: time.Now().Format(time.RFC3339),
+	}))
+
+	cfg := &config.Config{
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "reporting", Key: "reporting-key", Scopes: []string{config.ScopeReadStatus}},
+		},
+		JWT: config.JWTAuth{
+			JWKSFile:     jwksPath,
+			Issuer:       "https://idp.example.com",
+			Audience:     "smtogo",
+			NameClaim:    "sub",
+			ScopeClaim:   "scope",
+			SendersClaim: "allowed_senders",
+		},
+	}
+	router := api.NewServer(cfg, api.WithResultStore(results)).GetRouter()
+
+	token := func(scope string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   "https://idp.example.com",
+			"aud":   "smtogo",
+			"sub":   "reporting",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": scope,
+		})
+		tok.Header["kid"] = "test"
+		signed, _ := tok.SignedString(key)
+		return signed
+	}
+	getStatus := func(emailID, authorization string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/mail/status/"+emailID, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(authorization string) *httptest.ResponseRecorder {
+		return getStatus("00000000-0000-0000-0000-000000000000", authorization)
+	}
+
+	// Test a token with the scope reaches the handler
+	assert.Equal(t, http.StatusNotFound, get("Bearer "+token("read-status")).Code)
+
+	// Test a token whose subject matches an API key name cannot read the
+	// key's results
+	assert.Equal(t, http.StatusNotFound, getStatus(keyResult, "Bearer "+token("read-status")).Code)
+	req, _ := http.NewRequest("GET", "/v1/mail/status/"+keyResult, nil)
+	req.Header.Set("X-API-Key", "reporting-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Test missing scopes, bad tokens and missing credentials are rejected
+	assert.Equal(t, http.StatusForbidden, get("Bearer "+token("send")).Code)
+	assert.Equal(t, http.StatusForbidden, get("Bearer not.a.token").Code)
+	assert.Equal(t, http.StatusForbidden, get("").Code)
+}
+
+// issueCert creates a certificate for cn signed by parent, or a
+// self-signed CA when parent is nil
+func issueCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+// writePEM writes a certificate and optionally its key as PEM files
+func writePEM(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600))
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	return pair
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issueCert(t, "Test CA", nil, nil)
+	writePEM(t, dir, "ca", ca, caKey)
+	serverCert, serverKey := issueCert(t, "localhost", ca, caKey)
+	writePEM(t, dir, "server", serverCert, serverKey)
+	billingCert, billingKey := issueCert(t, "billing", ca, caKey)
+	billing := writePEM(t, dir, "billing", billingCert, billingKey)
+	strangerCert, strangerKey := issueCert(t, "stranger", ca, caKey)
+	stranger := writePEM(t, dir, "stranger", strangerCert, strangerKey)
+
+	// A result sent with the API key named like the certificate principal
+	dataDir := t.TempDir()
+	results := store.OpenFileStore(dataDir, nil)
+	keyResult := "11111111-1111-1111-1111-111111111111"
+	assert.NoError(t, results.Save(context.Background(), &models.EmailResult{
+		EmailID: keyResult, Status: "success", APIKeyName: "reader", Timestamp: time.Now().Format(time.RFC3339),
+	}))
+
+	cfg := &config.Config{
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "reader", Key: "read-key", Scopes: []string{config.ScopeReadStatus}},
+		},
+		TLS: config.TLSConfig{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+			ClientCerts: []config.ClientCert{
+				{Subject: "CN=billing,O=Example", Name: "reader", Scopes: []string{config.ScopeReadStatus}},
+			},
+		},
+	}
+	server := api.NewServer(cfg, api.WithResultStore(results))
+	tlsConfig, err := server.TLSConfig()
+	assert.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(server.GetRouter())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	getStatus := func(emailID string, cert *tls.Certificate, key string) int {
+		tlsClient := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tlsClient.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsClient}}
+		req, _ := http.NewRequest("GET", srv.URL+"/v1/mail/status/"+emailID, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(cert *tls.Certificate, key string) int {
+		return getStatus("00000000-0000-0000-0000-000000000000", cert, key)
+	}
+
+	// Test a mapped certificate authenticates without an API key
+	assert.Equal(t, http.StatusNotFound, get(&billing, ""))
+
+	// Test a certificate named like an API key cannot read the key's results
+	assert.Equal(t, http.StatusNotFound, getStatus(keyResult, &billing, ""))
+	assert.Equal(t, http.StatusOK, getStatus(keyResult, nil, "read-key"))
+
+	// Test an unmapped certificate or none falls back to the API key
+	assert.Equal(t, http.StatusForbidden, get(&stranger, ""))
+	assert.Equal(t, http.StatusNotFound, get(&stranger, "read-key"))
+	assert.Equal(t, http.StatusForbidden, get(nil, ""))
+	assert.Equal(t, http.StatusNotFound, get(nil, "read-key"))
+
+	// Test certificates are required when configured
+	cfg.TLS.RequireClientCert = true
+	tlsConfig, err = server.TLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+}
+
+func TestClientIPAllowlists(t *testing.T) {
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		return api.NewServer(&config.Config{
+			SenderEmail:    "noreply@example.com",
+			DataDir:        t.TempDir(),
+			TrustedProxies: trustedProxies,
+			AllowedIPs:     config.AllowedIPs{Send: []string{"10.0.0.0/8"}},
+			APIKeys: []config.APIKey{
+				{Name: "internal", Key: "internal-key", Scopes: []string{config.ScopeAdmin}, AllowedIPs: []string{"10.0.0.0/8"}},
+			},
+		}).GetRouter()
+	}
+	do := func(router *gin.Engine, method, path, forwardedFor string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "internal-key")
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	status := "/v1/mail/status/00000000-0000-0000-0000-000000000000"
+
+	// httptest requests come from 192.0.2.1. Without trusted proxies,
+	// forwarding headers cannot be used to spoof an allowed address.
+	untrusted := newRouter(nil)
+	assert.Equal(t, http.StatusForbidden, do(untrusted, "POST", "/v1/mail/send", "10.1.2.3"))
+	assert.Equal(t, http.StatusForbidden, do(untrusted, "GET", status, "10.1.2.3"))
+
+	// Test the forwarded address is used behind a trusted proxy
+	trusted := newRouter([]string{"192.0.2.0/24"})
+	assert.Equal(t, http.StatusBadRequest, do(trusted, "POST", "/v1/mail/send", "10.1.2.3"))
+	assert.Equal(t, http.StatusNotFound, do(trusted, "GET", status, "10.1.2.3"))
+	assert.Equal(t, http.StatusForbidden, do(trusted, "POST", "/v1/mail/send", "203.0.113.9"))
+
+	// Test the key allowlist applies on routes without a route allowlist
+	assert.Equal(t, http.StatusForbidden, do(trusted, "GET", status, "203.0.113.9"))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cfg := &config.Config{
+		SenderEmail: "noreply@example.com",
+		DataDir:     t.TempDir(),
+		AllowedIPs:  config.AllowedIPs{Metrics: []string{"192.0.2.0/24"}},
+	}
+	router := api.NewServer(cfg).GetRouter()
+	get := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	get("/health", "192.0.2.1:1234")
+	get("/no-such-path", "192.0.2.1:1234")
+
+	rr := get("/metrics", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `smtogo_http_request_duration_seconds_count{method="GET",route="/health",status="200"}`)
+	assert.Contains(t, body, `route="unmatched",status="404"`)
+	assert.Contains(t, body, "smtogo_email_queue_depth")
+
+	// Test the metrics allowlist
+	assert.Equal(t, http.StatusForbidden, get("/metrics", "203.0.113.9:1234").Code)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLogCorrelation(t *testing.T) {
+	var logs syncBuffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(config.Logging{Level: "info", Format: "json"}, &logs))
+
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "sender", Key: "send-key", Scopes: []string{config.ScopeSend}},
+		},
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	send := func(requestID string) *httptest.ResponseRecorder {
+		body := `{"recipient_email": "to@example.com", "subject": "Hi", "body": "Hello", "body_type": "plain"}`
+		req, _ := http.NewRequest("POST", "/v1/mail/send", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "send-key")
+		req.Header.Set("X-Request-ID", requestID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test an invalid request ID is replaced
+	rr := send("bad id\nwith newline")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, rr.Header().Get("X-Request-ID"), 36)
+	var other struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &other))
+	waitForResult(t, dataDir, other.EmailID)
+
+	// Test a valid request ID is kept and stored with the result
+	rr = send("req-123")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "req-123", rr.Header().Get("X-Request-ID"))
+	var queued struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	waitForResult(t, dataDir, queued.EmailID)
+
+	matches, _ := filepath.Glob(filepath.Join(dataDir, "*", "*", queued.EmailID+".json"))
+	stored, err := os.ReadFile(matches[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(stored), `"request_id": "req-123"`)
+	assert.NotContains(t, string(stored), "send-key")
+
+	// Test the failed send is logged with both IDs and no credentials
+	var failure map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "Failed to send email") && strings.Contains(line, queued.EmailID) {
+			assert.NoError(t, json.Unmarshal([]byte(line), &failure))
+		}
+	}
+	assert.Equal(t, "req-123", failure["request_id"])
+	assert.Equal(t, queued.EmailID, failure["email_id"])
+	assert.NotContains(t, logs.String(), "send-key")
+}
+
+func TestTracePropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+
+	dataDir := t.TempDir()
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg).GetRouter()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	body := `{"recipient_email": "to@example.com", "subject": "Hi", "body": "Hello", "body_type": "plain"}`
+	req, _ := http.NewRequest("POST", "/v1/mail/send", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var queued struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	waitForResult(t, dataDir, queued.EmailID)
+
+	// Test the stored result links back to the request's trace
+	matches, _ := filepath.Glob(filepath.Join(dataDir, "*", "*", queued.EmailID+".json"))
+	stored, err := os.ReadFile(matches[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(stored), `"traceparent": "00-`+traceID+`-`)
+
+	// The delivery span ends after the result is written
+	spans := map[string]tracetest.SpanStub{}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+		if _, ok := spans["deliver email"]; ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Test every span belongs to the incoming trace
+	for _, name := range []string{"POST /v1/mail/send", "validate request", "enqueue email", "deliver email", "smtp.dial"} {
+		if span, ok := spans[name]; assert.True(t, ok, name) {
+			assert.Equal(t, traceID, span.SpanContext.TraceID().String(), name)
+		}
+	}
+	assert.Equal(t, "00f067aa0ba902b7", spans["POST /v1/mail/send"].Parent.SpanID().String())
+	assert.Equal(t, spans["enqueue email"].SpanContext.SpanID(), spans["deliver email"].Parent.SpanID())
+}
+
+func TestListEmails(t *testing.T) {
+	dataDir := t.TempDir()
+	results, err := store.OpenSQLiteStore(filepath.Join(dataDir, "emails.db"), nil)
+	assert.NoError(t, err)
+	defer results.Close()
+
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		APIKeys: []config.APIKey{
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin, config.ScopeSend}},
+		},
+
+		MaxLenRecipientEmail: 64,
+		MaxLenSubject:        255,
+		MaxLenBody:           50000,
+	}
+	router := api.NewServer(cfg, api.WithResultStore(results)).GetRouter()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "admin-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/v1/mail/send", `{"recipient_email": "to@example.com", "subject": "Hi", "body": "Hello", "body_type": "plain"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var queued struct {
+		EmailID string `json:"email_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+
+	// Test the failed send is recorded in the configured store
+	var list struct {
+		Emails []models.EmailResult `json:"emails"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(list.Emails) == 0 && time.Now().Before(deadline) {
+		rr = do("GET", "/v1/admin/emails?status=failure&recipient=TO@example.com", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.Len(t, list.Emails, 1) {
+		assert.Equal(t, queued.EmailID, list.Emails[0].EmailID)
+		assert.Equal(t, "to@example.com", list.Emails[0].Recipient)
+	}
+
+	rr = do("GET", "/v1/mail/status/"+queued.EmailID, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"failure"`)
+
+	// Test filters are applied and validated
+	rr = do("GET", "/v1/admin/emails?status=success", "")
+	assert.Equal(t, `{"emails":[]}`, rr.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/v1/admin/emails?since=yesterday", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/v1/admin/emails?limit=0", "").Code)
+}
+
+func TestArchiveAPI(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	results := store.OpenFileStore(dataDir, nil)
+	day := time.Date(2024, 3, 14, 12, 0, 0, 0, time.Local)
+	for _, id := range []string{"first", "second"} {
+		assert.NoError(t, results.Save(ctx, &models.EmailResult{
+			EmailID: id, Status: "success", Recipient: id + "@example.com", Timestamp: day.Format(time.RFC3339),
+		}))
+	}
+
+	cfg := &config.Config{
+		SMTPServer:  "127.0.0.1",
+		SMTPPort:    1,
+		SenderEmail: "noreply@example.com",
+		DataDir:     dataDir,
+		Archive:     config.Archive{Enabled: true},
+		APIKeys: []config.APIKey{
+			{Name: "ops", Key: "admin-key", Scopes: []string{config.ScopeAdmin}},
+		},
+	}
+	archived, err := archive.Days(ctx, results, cfg.ArchiveDir(), nil, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, archived)
+	router := api.NewServer(cfg, api.WithResultStore(results)).GetRouter()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/v1/admin/archive")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"2024-03-14.jsonl.gz"`)
+
+	rr = get("/v1/admin/archive/search?recipient=second@example.com")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var found struct {
+		Emails []models.EmailResult `json:"emails"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &found))
+	if assert.Len(t, found.Emails, 1) {
+		assert.Equal(t, "second", found.Emails[0].EmailID)
+	}
+
+	rr = get("/v1/admin/archive/emails/first?day=2024-03-14")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"email_id":"first"`)
+	assert.Equal(t, http.StatusNotFound, get("/v1/admin/archive/emails/first?day=2024-03-15").Code)
+
+	// Test bundles download as gzip and only by listed name
+	rr = get("/v1/admin/archive/bundles/2024-03-14.jsonl.gz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	gz, err := gzip.NewReader(rr.Body)
+	if assert.NoError(t, err) {
+		content, _ := io.ReadAll(gz)
+		assert.Equal(t, 2, strings.Count(string(content), "\n"))
+	}
+	assert.Equal(t, http.StatusNotFound, get("/v1/admin/archive/bundles/..%2Femails.db").Code)
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/archive"
+	"github.com/hnrobert/smtogo/internal/auth"
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sources of API keys shown by the admin API
+const (
+	keySourceConfig = "config"
+	keySourceStore  = "store"
+)
+
+// listAPIKeys lists configured and managed API keys without their secrets
+func (s *Server) listAPIKeys(c *gin.Context) {
+	keys := []models.APIKeyInfo{}
+	for _, k := range s.getConfig().AllAPIKeys() {
+		keys = append(keys, models.APIKeyInfo{
+			Name:               k.Name,
+			Source:             keySourceConfig,
+			Scopes:             k.Scopes,
+			AllowedSenders:     k.AllowedSenders,
+			AllowedIPs:         k.AllowedIPs,
+			ExpiresAt:          k.ExpiresAt,
+			RateLimitPerMinute: k.RateLimitPerMinute,
+			DailyQuota:         k.DailyQuota,
+			MonthlyQuota:       k.MonthlyQuota,
+		})
+	}
+	for _, k := range s.keyStore.List() {
+		keys = append(keys, storedKeyInfo(&k, ""))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// createAPIKey creates a managed API key. The secret is returned only in
+// this response.
+func (s *Server) createAPIKey(c *gin.Context) {
+	var req models.APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateAPIKeyRequest(s.getConfig(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.nameInUse(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("an API key named %q already exists", req.Name)})
+		return
+	}
+
+	secret, key, err := s.keyStore.Create(auth.StoredKey{
+		Name:               req.Name,
+		Scopes:             req.Scopes,
+		AllowedSenders:     req.AllowedSenders,
+		AllowedIPs:         req.AllowedIPs,
+		ExpiresAt:          req.ExpiresAt,
+		RateLimitPerMinute: req.RateLimitPerMinute,
+		DailyQuota:         req.DailyQuota,
+		MonthlyQuota:       req.MonthlyQuota,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, storedKeyInfo(key, secret))
+}
+
+// rotateAPIKey replaces the secret of a managed API key
+func (s *Server) rotateAPIKey(c *gin.Context) {
+	secret, key, err := s.keyStore.Rotate(c.Param("id"))
+	if err != nil {
+		respondKeyStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, storedKeyInfo(key, secret))
+}
+
+// revokeAPIKey disables a managed API key
+func (s *Server) revokeAPIKey(c *gin.Context) {
+	key, err := s.keyStore.Revoke(c.Param("id"))
+	if err != nil {
+		respondKeyStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, storedKeyInfo(key, ""))
+}
+
+// validateAPIKeyRequest checks the name, scopes, sender identities, IP
+// ranges, limits and expiry
+func validateAPIKeyRequest(cfg *config.Config, req *models.APIKeyRequest) error {
+	if config.ReservedKeyName(req.Name) {
+		return fmt.Errorf("name %q uses a prefix reserved for other principals", req.Name)
+	}
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("scopes must list at least one scope")
+	}
+	for _, scope := range req.Scopes {
+		if !config.ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	for _, id := range req.AllowedSenders {
+		if _, ok := cfg.FindSender(id); !ok {
+			return fmt.Errorf("unknown sender identity %q", id)
+		}
+	}
+	for _, r := range req.AllowedIPs {
+		if _, err := config.ParseCIDR(r); err != nil {
+			return fmt.Errorf("allowed_ips: %v", err)
+		}
+	}
+	if req.RateLimitPerMinute < 0 || req.DailyQuota < 0 || req.MonthlyQuota < 0 {
+		return fmt.Errorf("rate_limit_per_minute, daily_quota and monthly_quota must not be negative")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// nameInUse reports whether a configured or managed key has name, even a
+// revoked one. Names must be unique because they identify the key in email
+// results and quota counters.
+func (s *Server) nameInUse(name string) bool {
+	for _, k := range s.getConfig().AllAPIKeys() {
+		if k.Name == name {
+			return true
+		}
+	}
+	return s.keyStore.HasName(name)
+}
+
+// respondKeyStoreError maps key store errors to HTTP responses
+func respondKeyStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrKeyRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// storedKeyInfo describes a managed key, with its secret if just issued
+func storedKeyInfo(k *auth.StoredKey, secret string) models.APIKeyInfo {
+	createdAt := k.CreatedAt
+	return models.APIKeyInfo{
+		ID:             k.ID,
+		Name:           k.Name,
+		Source:         keySourceStore,
+		Key:            secret,
+		Hint:           k.Hint,
+		Scopes:         k.Scopes,
+		AllowedSenders: k.AllowedSenders,
+		AllowedIPs:     k.AllowedIPs,
+		CreatedAt:      &createdAt,
+		RotatedAt:      k.RotatedAt,
+		ExpiresAt:      k.ExpiresAt,
+		RevokedAt:      k.RevokedAt,
+
+		RateLimitPerMinute: k.RateLimitPerMinute,
+		DailyQuota:         k.DailyQuota,
+		MonthlyQuota:       k.MonthlyQuota,
+	}
+}
+
+// Limits on the number of results listed by the admin API
+const (
+	defaultEmailListLimit = 100
+	maxEmailListLimit     = 1000
+)
+
+// listEmails lists stored email results, newest first, filtered by the
+// query parameters described at parseResultQuery
+func (s *Server) listEmails(c *gin.Context) {
+	q, err := parseResultQuery(c, s.getConfig().Redaction)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := s.results.List(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"emails": results})
+}
+
+// listArchiveBundles lists the archive bundles, newest day first
+func (s *Server) listArchiveBundles(c *gin.Context) {
+	bundles, err := archive.List(s.getConfig().ArchiveDir())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bundles": bundles})
+}
+
+// searchArchive lists archived email results, newest day first, filtered
+// like listEmails
+func (s *Server) searchArchive(c *gin.Context) {
+	q, err := parseResultQuery(c, s.getConfig().Redaction)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := archive.Search(c.Request.Context(), s.getConfig().ArchiveDir(), s.keys, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"emails": results})
+}
+
+// getArchivedEmail extracts one archived result. The optional day query
+// parameter (YYYY-MM-DD) limits the search to that day's bundles.
+func (s *Server) getArchivedEmail(c *gin.Context) {
+	result, err := archive.Extract(c.Request.Context(), s.getConfig().ArchiveDir(), s.keys, c.Param("email_id"), c.Query("day"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "email not found in the archive"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// downloadArchiveBundle serves a whole bundle as stored, so encrypted
+// bundles stay encrypted. Only names listed in the archive directory are
+// served.
+func (s *Server) downloadArchiveBundle(c *gin.Context) {
+	dir := s.getConfig().ArchiveDir()
+	bundles, err := archive.List(dir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, bundle := range bundles {
+		if bundle.Name == c.Param("name") {
+			c.FileAttachment(filepath.Join(dir, bundle.Name), bundle.Name)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "archive bundle not found"})
+}
+
+// parseResultQuery reads the status, recipient, since and until (RFC 3339)
+// and limit query parameters. With masked recipients, a full address is
+// matched by its mask.
+func parseResultQuery(c *gin.Context, policy config.Redaction) (store.Query, error) {
+	q := store.Query{
+		Status:    c.Query("status"),
+		Recipient: c.Query("recipient"),
+		Limit:     defaultEmailListLimit,
+	}
+	if q.Recipient != "" && policy.Recipient == config.RedactMask {
+		q.Recipient = logging.MaskEmail(q.Recipient)
+	}
+
+	var err error
+	if v := c.Query("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, errors.New("since must be an RFC 3339 time")
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, errors.New("until must be an RFC 3339 time")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxEmailListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxEmailListLimit)
+		}
+	}
+	return q, nil
+}
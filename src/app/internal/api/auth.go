@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/auth"
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the context key for the authenticated *auth.Principal
+const principalKey = "principal"
+
+// apiKeyAuthMiddleware validates the X-API-Key header against the configured
+// and managed keys if authentication is enabled. The check is made per
+// request so that reloading the config or the admin API can add, revoke or
+// rotate keys.
+func (s *Server) apiKeyAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Already authenticated by a signature, bearer token or client
+		// certificate
+		if _, ok := c.Get(principalKey); ok {
+			c.Next()
+			return
+		}
+
+		cfg := s.getConfig()
+		if !cfg.IsAPIKeyAuthEnabled() && !s.keyStore.Active() && !cfg.IsJWTAuthEnabled() && !cfg.IsClientCertAuthEnabled() {
+			c.Set(principalKey, auth.Anonymous)
+			c.Next()
+			return
+		}
+
+		presented := c.GetHeader("X-API-Key")
+		principal, err := auth.MatchAPIKey(cfg.AllAPIKeys(), presented, time.Now())
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			principal, err = s.keyStore.Match(presented, time.Now())
+		}
+		if err != nil {
+			detail := "Could not validate credentials"
+			if errors.Is(err, auth.ErrExpiredAPIKey) || errors.Is(err, auth.ErrSignatureRequired) {
+				detail = err.Error()
+			}
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": detail,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// maxSignedBodySize caps how much of a signed request body is read to
+// verify its digest
+const maxSignedBodySize = 10 << 20
+
+// signatureAuthMiddleware authenticates requests signed with HMAC-SHA256.
+// Unsigned requests are passed on to apiKeyAuthMiddleware.
+func (s *Server) signatureAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(auth.HeaderSignature) == "" {
+			c.Next()
+			return
+		}
+
+		// Read the body for its digest and put it back for the handler
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBodySize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+				c.Abort()
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		cfg := s.getConfig()
+		principal, err := auth.VerifySignature(cfg.AllAPIKeys(), &auth.SignedRequest{
+			Method:        c.Request.Method,
+			RequestURI:    c.Request.URL.RequestURI(),
+			Body:          body,
+			KeyID:         c.GetHeader(auth.HeaderKeyID),
+			Timestamp:     c.GetHeader(auth.HeaderTimestamp),
+			Nonce:         c.GetHeader(auth.HeaderNonce),
+			ContentSHA256: c.GetHeader(auth.HeaderContentSHA256),
+			Signature:     c.GetHeader(auth.HeaderSignature),
+		}, time.Now(), time.Duration(cfg.SignatureMaxSkew)*time.Second, s.nonces)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// bearerAuthMiddleware authenticates requests carrying a JWT in the
+// Authorization header when JWT authentication is enabled. Other requests
+// are passed on to apiKeyAuthMiddleware.
+func (s *Server) bearerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verifier := s.jwtVerifier.Load()
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if verifier == nil || !ok {
+			c.Next()
+			return
+		}
+		if _, ok := c.Get(principalKey); ok {
+			c.Next()
+			return
+		}
+
+		principal, err := verifier.Verify(token, time.Now())
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Could not validate bearer token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(header string) (string, bool) {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// clientCertAuthMiddleware authenticates requests made over mutual TLS with
+// a verified client certificate whose subject is mapped to a principal.
+// Requests without one are passed on to apiKeyAuthMiddleware, so an API key
+// can supplement or replace the certificate.
+func (s *Server) clientCertAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(principalKey); ok {
+			c.Next()
+			return
+		}
+
+		// Only certificates verified against the client CA count
+		state := c.Request.TLS
+		if state == nil || len(state.VerifiedChains) == 0 {
+			c.Next()
+			return
+		}
+
+		principal, err := auth.MatchClientCert(s.getConfig().TLS.ClientCerts, state.VerifiedChains[0][0])
+		if err == nil {
+			c.Set(principalKey, principal)
+		}
+		c.Next()
+	}
+}
+
+// routeAllowedIPs returns the client IP ranges allowed on a route
+func routeAllowedIPs(cfg *config.Config, route string) []string {
+	switch {
+	case route == "/v1/mail/send":
+		return cfg.AllowedIPs.Send
+	case strings.HasPrefix(route, "/v1/mail/status/"):
+		return cfg.AllowedIPs.Status
+	case route == "/v1/mail/usage":
+		return cfg.AllowedIPs.Usage
+	case strings.HasPrefix(route, "/v1/admin/"):
+		return cfg.AllowedIPs.Admin
+	case route == "/metrics":
+		return cfg.AllowedIPs.Metrics
+	case strings.HasPrefix(route, "/health"):
+		return cfg.AllowedIPs.Health
+	}
+	return nil
+}
+
+// ipAllowlistMiddleware rejects clients outside the IP ranges allowed on
+// the route. It runs before authentication so that other clients cannot
+// probe credentials.
+func (s *Server) ipAllowlistMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.IPAllowed(routeAllowedIPs(s.getConfig(), c.FullPath()), s.getClientIP(c)) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "client IP is not allowed",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// keyIPAllowlistMiddleware rejects authenticated callers connecting from
+// outside the IP ranges allowed for their key
+func (s *Server) keyIPAllowlistMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getPrincipal(c).CanConnectFrom(s.getClientIP(c)) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "client IP is not allowed for this API key",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authMiddleware returns the handlers that limit and authenticate API
+// requests, in order
+func (s *Server) authMiddleware() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		s.ipAllowlistMiddleware(),
+		s.ipRateLimitMiddleware(),
+		s.signatureAuthMiddleware(),
+		s.bearerAuthMiddleware(),
+		s.clientCertAuthMiddleware(),
+		s.apiKeyAuthMiddleware(),
+		s.keyIPAllowlistMiddleware(),
+		s.keyRateLimitMiddleware(),
+	}
+}
+
+// requireScope rejects callers whose principal lacks scope
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getPrincipal(c).HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("API key lacks the %q scope", scope),
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAuthenticated rejects anonymous callers, so that endpoints such as
+// the admin API stay closed while authentication is disabled
+func requireAuthenticated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if getPrincipal(c) == auth.Anonymous {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key authentication must be enabled to use this endpoint",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getPrincipal returns the authenticated caller. Routes without
+// authentication middleware are treated as anonymous.
+func getPrincipal(c *gin.Context) *auth.Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*auth.Principal)
+	}
+	return auth.Anonymous
+}
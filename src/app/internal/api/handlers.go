@@ -1,14 +1,24 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/metrics"
 	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+	"github.com/hnrobert/smtogo/internal/tracing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // sendEmail handles the JSON email sending endpoint
@@ -19,25 +29,65 @@ func (s *Server) sendEmail(c *gin.Context) {
 		return
 	}
 
-	// Validate email request
-	if err := s.validateEmailRequest(&req); err != nil {
+	cfg := s.getConfig()
+
+	// Validate the email request and resolve the sender identity
+	_, span := tracing.Start(c.Request.Context(), "validate request")
+	identity, err := validateAndResolve(cfg, &req)
+	tracing.End(span, err)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Check the caller may use the sender identity
+	principal := getPrincipal(c)
+	if !principal.CanUseSender(identity.ID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("not allowed to send as %q", identity.ID),
+		})
+		return
+	}
+	req.SenderID = identity.ID
+
+	// Count the send against the caller's quotas
+	if !s.reserveQuota(c, cfg, principal) {
+		return
+	}
+
 	// Generate email ID
 	emailID := uuid.New().String()
 
-	// Get client info
-	clientIP := getClientIP(c)
-	headers := getHeaders(c)
+	// The send outlives the request, so it keeps the request's logger and
+	// values but not its cancellation. It is linked to the request's trace
+	// through the enqueue span.
+	logger := requestLogger(c).With("email_id", emailID).
+		With(logging.MessageAttrs(cfg.Redaction, req.RecipientEmail, req.Subject)...)
+	ctx, span := tracing.Start(context.WithoutCancel(c.Request.Context()), "enqueue email",
+		trace.WithAttributes(attribute.String("email_id", emailID), attribute.String("sender_id", identity.ID)))
+	defer span.End()
+	ctx = logging.WithLogger(ctx, logger)
+	logger.Info("Email accepted", "sender_id", identity.ID, "principal", principal.Name)
+
+	// Fields known at request time
+	result := models.EmailResult{
+		EmailID:     emailID,
+		ClientIP:    s.getClientIP(c),
+		Headers:     logging.RedactHeaders(cfg.Redaction, c.Request.Header),
+		APIKeyName:  principal.Name,
+		RequestID:   c.Writer.Header().Get(requestIDHeader),
+		TraceParent: tracing.TraceParent(ctx),
+	}
 
-	// Send email asynchronously
+	// Send email asynchronously; failures are logged and stored by the
+	// sender
+	metrics.EmailsAccepted.WithLabelValues(identity.Relay.Address(), identity.ID).Inc()
+	metrics.QueueDepth.Inc()
+	s.inFlight.Add(1)
 	go func() {
-		err := s.emailSender.SendEmail(&req, emailID, clientIP, headers, nil)
-		if err != nil {
-			fmt.Printf("Failed to send email %s: %v\n", emailID, err)
-		}
+		defer s.inFlight.Add(-1)
+		defer metrics.QueueDepth.Dec()
+		s.emailSender.SendEmail(ctx, &req, result, nil)
 	}()
 
 	c.JSON(http.StatusOK, gin.H{
@@ -46,21 +96,48 @@ func (s *Server) sendEmail(c *gin.Context) {
 	})
 }
 
+// getEmailStatus returns the stored result of an email. Callers without
+// the admin scope only see results of emails they sent.
+func (s *Server) getEmailStatus(c *gin.Context) {
+	// Only UUIDs are accepted, which also keeps the ID out of path tricks
+	emailID := c.Param("email_id")
+	if _, err := uuid.Parse(emailID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email ID"})
+		return
+	}
+
+	result, err := s.results.Get(c.Request.Context(), emailID)
+	principal := getPrincipal(c)
+	if err == nil && !principal.HasScope(config.ScopeAdmin) && result.APIKeyName != principal.Name {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 // validateEmailRequest validates the email request
-func (s *Server) validateEmailRequest(req *models.EmailRequest) error {
+func validateEmailRequest(cfg *config.Config, req *models.EmailRequest) error {
 	// Validate recipient email length
-	if len(req.RecipientEmail) > s.config.MaxLenRecipientEmail {
-		return fmt.Errorf("email address must be less than %d characters", s.config.MaxLenRecipientEmail)
+	if len(req.RecipientEmail) > cfg.MaxLenRecipientEmail {
+		return fmt.Errorf("email address must be less than %d characters", cfg.MaxLenRecipientEmail)
 	}
 
 	// Validate subject length
-	if len(req.Subject) > s.config.MaxLenSubject {
-		return fmt.Errorf("subject must be less than %d characters", s.config.MaxLenSubject)
+	if len(req.Subject) > cfg.MaxLenSubject {
+		return fmt.Errorf("subject must be less than %d characters", cfg.MaxLenSubject)
 	}
 
 	// Validate body length
-	if len(req.Body) > s.config.MaxLenBody {
-		return fmt.Errorf("body content must be less than %d characters", s.config.MaxLenBody)
+	if len(req.Body) > cfg.MaxLenBody {
+		return fmt.Errorf("body content must be less than %d characters", cfg.MaxLenBody)
 	}
 
 	// Validate body type
@@ -76,37 +153,63 @@ func (s *Server) validateEmailRequest(req *models.EmailRequest) error {
 	return nil
 }
 
-// getClientIP extracts the client IP address
-func getClientIP(c *gin.Context) string {
-	// Check X-Real-IP header first
-	if ip := c.GetHeader("X-Real-IP"); ip != "" {
-		return ip
+// validateAndResolve validates the email request and returns the sender
+// identity it selects
+func validateAndResolve(cfg *config.Config, req *models.EmailRequest) (*config.SenderIdentity, error) {
+	if err := validateEmailRequest(cfg, req); err != nil {
+		return nil, err
 	}
+	return resolveSender(cfg, req)
+}
+
+// resolveSender finds the sender identity selected by the request's from
+// or sender_id field, falling back to the default identity
+func resolveSender(cfg *config.Config, req *models.EmailRequest) (*config.SenderIdentity, error) {
+	var identity *config.SenderIdentity
+	var ok bool
 
-	// Check X-Forwarded-For header
-	if ip := c.GetHeader("X-Forwarded-For"); ip != "" {
-		// Take the first IP from the list
-		if parts := strings.Split(ip, ","); len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
+	if req.From != "" {
+		if identity, ok = cfg.FindSenderByEmail(req.From); !ok {
+			return nil, fmt.Errorf("unknown sender address %q", req.From)
 		}
+		if req.SenderID != "" && req.SenderID != identity.ID {
+			return nil, fmt.Errorf("from %q does not belong to sender_id %q", req.From, req.SenderID)
+		}
+		return identity, nil
 	}
 
-	// Fall back to remote address
-	return c.ClientIP()
+	if identity, ok = cfg.FindSender(req.SenderID); !ok {
+		if req.SenderID == "" {
+			return nil, fmt.Errorf("no default sender identity is configured")
+		}
+		return nil, fmt.Errorf("unknown sender_id %q", req.SenderID)
+	}
+	return identity, nil
 }
 
-// getHeaders extracts request headers
-func getHeaders(c *gin.Context) map[string]string {
-	headers := make(map[string]string)
-	for key, values := range c.Request.Header {
-		if len(values) > 0 {
-			// Remove sensitive headers
-			if strings.ToLower(key) != "x-api-key" {
-				headers[key] = values[0]
-			}
+// getClientIP returns the client IP address. Forwarding headers are only
+// honoured when the connection comes from a trusted proxy, which Gin checks
+// against trusted_proxies. Connections over the Unix socket can only come
+// from a local reverse proxy, so the address it appended to
+// X-Forwarded-For is used, or X-Real-IP.
+func (s *Server) getClientIP(c *gin.Context) string {
+	if ip := c.ClientIP(); ip != "" {
+		return ip
+	}
+	if network, _ := s.getConfig().ListenAddress(); network != "unix" {
+		return ""
+	}
+
+	if forwarded := c.GetHeader("X-Forwarded-For"); forwarded != "" {
+		parts := strings.Split(forwarded, ",")
+		if ip := strings.TrimSpace(parts[len(parts)-1]); net.ParseIP(ip) != nil {
+			return ip
 		}
 	}
-	return headers
+	if ip := strings.TrimSpace(c.GetHeader("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return ""
 }
 
 // getFormValue safely extracts a form value
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/email"
+	"github.com/hnrobert/smtogo/internal/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check statuses reported by /health/ready
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// checkResult is the outcome of one readiness check
+type checkResult struct {
+	Status     string `json:"status"`
+	Detail     string `json:"detail"`
+	DurationMS int64  `json:"duration_ms"`
+
+	checkedAt time.Time
+}
+
+// smtpCheckCache keeps recent SMTP check results by relay and mode
+type smtpCheckCache struct {
+	mu      sync.Mutex
+	results map[string]checkResult
+}
+
+// getHealth handles health check requests
+func (s *Server) getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "healthy",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"version":   version.Version,
+	})
+}
+
+// getLiveness reports that the process is serving requests. It checks no
+// dependencies, so a failing relay does not get the service restarted.
+func (s *Server) getLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "alive",
+		"version": version.Version,
+	})
+}
+
+// getReadiness runs the readiness checks and answers 503 if any fails
+func (s *Server) getReadiness(c *gin.Context) {
+	cfg := s.getConfig()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.Health.CheckTimeout())
+	defer cancel()
+
+	checks := s.checkRelays(ctx, cfg)
+	checks["data_dir"] = timeCheck(func() (string, error) { return checkDataDir(cfg.DataDir) })
+	checks["queue"] = timeCheck(func() (string, error) { return s.checkQueue(cfg) })
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Status != checkOK {
+			status, code = "not ready", http.StatusServiceUnavailable
+			break
+		}
+	}
+	c.JSON(code, gin.H{
+		"status":  status,
+		"version": version.Version,
+		"checks":  checks,
+	})
+}
+
+// timeCheck runs check and records its outcome and duration
+func timeCheck(check func() (string, error)) checkResult {
+	start := time.Now()
+	detail, err := check()
+	result := checkResult{Status: checkOK, Detail: detail, checkedAt: start}
+	if err != nil {
+		result.Status = checkFail
+		result.Detail = err.Error()
+	}
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result
+}
+
+// checkRelays checks every relay used by a sender identity in parallel,
+// reusing results younger than health.smtp_check_interval. Each relay is
+// checked once; in auth mode it logs in as the first identity on it that
+// uses a password.
+func (s *Server) checkRelays(ctx context.Context, cfg *config.Config) map[string]checkResult {
+	checks := make(map[string]checkResult)
+	if cfg.Health.SMTPCheck == config.SMTPCheckOff {
+		return checks
+	}
+	authenticate := cfg.Health.SMTPCheck == config.SMTPCheckAuth
+
+	relays := make(map[string]*config.SenderIdentity)
+	for _, identity := range cfg.SenderIdentities() {
+		completed, _ := cfg.FindSender(identity.ID)
+		name := "smtp:" + completed.Relay.Address()
+		if first, ok := relays[name]; !ok || (authenticate && !first.UsePassword && completed.UsePassword) {
+			relays[name] = completed
+		}
+	}
+
+	maxAge := time.Duration(cfg.Health.SMTPCheckInterval) * time.Second
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, identity := range relays {
+		key := fmt.Sprintf("%s|%s|%t", name, identity.Email, authenticate)
+		if cached, ok := s.smtpChecks.get(key, maxAge); ok {
+			checks[name] = cached
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, identity *config.SenderIdentity) {
+			defer wg.Done()
+			result := timeCheck(func() (string, error) {
+				if err := email.CheckRelay(ctx, identity, authenticate, cfg.Health.CheckTimeout()); err != nil {
+					return "", err
+				}
+				if authenticate && identity.UsePassword {
+					return "authenticated as " + identity.Email, nil
+				}
+				return "connected", nil
+			})
+			s.smtpChecks.put(key, result)
+
+			mu.Lock()
+			checks[name] = result
+			mu.Unlock()
+		}(name, identity)
+	}
+	wg.Wait()
+	return checks
+}
+
+// checkDataDir checks that email results can be written
+func checkDataDir(dataDir string) (string, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", fmt.Errorf("data directory is not writable: %w", err)
+	}
+	f, err := os.CreateTemp(dataDir, ".health-*")
+	if err != nil {
+		return "", fmt.Errorf("data directory is not writable: %w", err)
+	}
+	name := f.Name()
+	_, err = f.WriteString("ok")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(name)
+	if err != nil {
+		return "", fmt.Errorf("data directory is not writable: %w", err)
+	}
+	return "writable", nil
+}
+
+// checkQueue compares the emails accepted but not yet sent against
+// health.max_queue_depth
+func (s *Server) checkQueue(cfg *config.Config) (string, error) {
+	depth := s.inFlight.Load()
+	limit := cfg.Health.MaxQueueDepth
+	if limit > 0 && depth > int64(limit) {
+		return "", fmt.Errorf("%d emails waiting to be sent, above the limit of %d", depth, limit)
+	}
+	if limit > 0 {
+		return fmt.Sprintf("%d emails waiting to be sent, limit %d", depth, limit), nil
+	}
+	return fmt.Sprintf("%d emails waiting to be sent", depth), nil
+}
+
+// get returns a cached result no older than maxAge
+func (c *smtpCheckCache) get(key string, maxAge time.Duration) (checkResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[key]
+	if !ok || time.Since(result.checkedAt) >= maxAge {
+		return checkResult{}, false
+	}
+	return result, true
+}
+
+// put stores a result
+func (c *smtpCheckCache) put(key string, result checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		c.results = make(map[string]checkResult)
+	}
+	c.results[key] = result
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request ID in requests and responses
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits which caller-supplied request IDs are reused, so
+// that they cannot inject arbitrary text into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware assigns every request an ID, reusing a valid
+// X-Request-ID from the caller or a proxy, and attaches a logger carrying
+// it to the request context
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger))
+		c.Next()
+	}
+}
+
+// accessLogMiddleware logs every request once it has been handled
+func (s *Server) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		requestLogger(c).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", s.getClientIP(c),
+			"principal", getPrincipal(c).Name,
+		)
+	}
+}
+
+// recoveryMiddleware logs panics in handlers and answers 500
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		requestLogger(c).Error("panic while handling request", "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// requestLogger returns the logger carrying the request ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/auth"
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyLimits holds the limits that apply to one API key
+type keyLimits struct {
+	PerMinute int `json:"per_minute"`
+	Daily     int `json:"daily"`
+	Monthly   int `json:"monthly"`
+}
+
+// limitsFor returns the limits of a principal, falling back to the
+// configured defaults where the key has no override
+func limitsFor(cfg *config.Config, p *auth.Principal) keyLimits {
+	limits := keyLimits{
+		PerMinute: cfg.RateLimit.PerKeyPerMinute,
+		Daily:     cfg.RateLimit.DailyQuota,
+		Monthly:   cfg.RateLimit.MonthlyQuota,
+	}
+	if p.RateLimitPerMinute > 0 {
+		limits.PerMinute = p.RateLimitPerMinute
+	}
+	if p.DailyQuota > 0 {
+		limits.Daily = p.DailyQuota
+	}
+	if p.MonthlyQuota > 0 {
+		limits.Monthly = p.MonthlyQuota
+	}
+	return limits
+}
+
+// ipRateLimitMiddleware limits requests per client IP. It runs before
+// authentication so that it also slows down key guessing.
+func (s *Server) ipRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := s.getConfig().RateLimit.PerIPPerMinute
+		if limit == 0 {
+			c.Next()
+			return
+		}
+
+		result := s.ipLimiter.Allow(s.getClientIP(c), limit, time.Now())
+		if !result.Allowed {
+			rejectRateLimited(c, result.Limit, result.Reset, "rate limit exceeded for client IP")
+			return
+		}
+		setRateLimitHeaders(c, result.Limit, result.Remaining, result.Reset)
+		c.Next()
+	}
+}
+
+// keyRateLimitMiddleware limits requests per authenticated API key. It
+// replaces the per-IP headers with the per-key ones, which are usually the
+// more useful to the client.
+func (s *Server) keyRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := getPrincipal(c)
+		limit := limitsFor(s.getConfig(), principal).PerMinute
+		if principal == auth.Anonymous || limit == 0 {
+			c.Next()
+			return
+		}
+
+		result := s.keyLimiter.Allow(principal.Name, limit, time.Now())
+		if !result.Allowed {
+			rejectRateLimited(c, result.Limit, result.Reset, "rate limit exceeded for API key")
+			return
+		}
+		setRateLimitHeaders(c, result.Limit, result.Remaining, result.Reset)
+		c.Next()
+	}
+}
+
+// reserveQuota counts a send against the caller's daily and monthly quotas.
+// It responds and returns false if the send is not allowed.
+func (s *Server) reserveQuota(c *gin.Context, cfg *config.Config, principal *auth.Principal) bool {
+	if principal == auth.Anonymous {
+		return true
+	}
+
+	limits := limitsFor(cfg, principal)
+	now := time.Now()
+	usage, ok := s.quota.Reserve(principal.Name, limits.Daily, limits.Monthly, now)
+	if !ok {
+		period, detail := usage.Daily, "daily send quota exceeded"
+		if !usage.Daily.Exceeded() {
+			period, detail = usage.Monthly, "monthly send quota exceeded"
+		}
+		rejectRateLimited(c, period.Limit, period.ResetAt.Sub(now), detail)
+		return false
+	}
+	return true
+}
+
+// getUsage reports the caller's rate limit and quota usage
+func (s *Server) getUsage(c *gin.Context) {
+	principal := getPrincipal(c)
+	limits := limitsFor(s.getConfig(), principal)
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":   principal.Name,
+		"limits": limits,
+		"usage":  s.quota.Usage(principal.Name, limits.Daily, limits.Monthly, time.Now()),
+	})
+}
+
+// listUsage reports quota usage of every configured and managed key
+func (s *Server) listUsage(c *gin.Context) {
+	cfg := s.getConfig()
+	now := time.Now()
+
+	type keyUsage struct {
+		Name   string          `json:"name"`
+		Limits keyLimits       `json:"limits"`
+		Usage  ratelimit.Usage `json:"usage"`
+	}
+	keys := []keyUsage{}
+	add := func(p *auth.Principal) {
+		limits := limitsFor(cfg, p)
+		keys = append(keys, keyUsage{
+			Name:   p.Name,
+			Limits: limits,
+			Usage:  s.quota.Usage(p.Name, limits.Daily, limits.Monthly, now),
+		})
+	}
+
+	for _, k := range cfg.AllAPIKeys() {
+		add(&auth.Principal{
+			Name:               k.Name,
+			RateLimitPerMinute: k.RateLimitPerMinute,
+			DailyQuota:         k.DailyQuota,
+			MonthlyQuota:       k.MonthlyQuota,
+		})
+	}
+	for _, k := range s.keyStore.List() {
+		if k.RevokedAt == nil {
+			add(&auth.Principal{
+				Name:               k.Name,
+				RateLimitPerMinute: k.RateLimitPerMinute,
+				DailyQuota:         k.DailyQuota,
+				MonthlyQuota:       k.MonthlyQuota,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// setRateLimitHeaders sets the RateLimit-* headers of the IETF draft
+func setRateLimitHeaders(c *gin.Context, limit, remaining int, reset time.Duration) {
+	c.Header("RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(resetSeconds(reset)))
+}
+
+// rejectRateLimited aborts with 429 Too Many Requests
+func rejectRateLimited(c *gin.Context, limit int, reset time.Duration, detail string) {
+	setRateLimitHeaders(c, limit, 0, reset)
+	c.Header("Retry-After", strconv.Itoa(resetSeconds(reset)))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": detail})
+	c.Abort()
+}
+
+// resetSeconds rounds a duration up to whole seconds
+func resetSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
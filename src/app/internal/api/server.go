@@ -1,31 +1,108 @@
 package api
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/hnrobert/smtogo/internal/auth"
 	"github.com/hnrobert/smtogo/internal/config"
 	"github.com/hnrobert/smtogo/internal/email"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/metrics"
+	"github.com/hnrobert/smtogo/internal/ratelimit"
+	"github.com/hnrobert/smtogo/internal/store"
+	"github.com/hnrobert/smtogo/internal/version"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Server represents the API server
 type Server struct {
-	config      *config.Config
+	config      atomic.Pointer[config.Config]
 	emailSender *email.Sender
+	keyStore    *auth.KeyStore
+	quota       *ratelimit.QuotaTracker
+	results     store.Store
+	keys        *encryption.Keyring
+	ipLimiter   *ratelimit.Limiter
+	keyLimiter  *ratelimit.Limiter
+	nonces      *auth.NonceCache
+	jwtVerifier atomic.Pointer[auth.JWTVerifier]
+	inFlight    atomic.Int64
+	smtpChecks  smtpCheckCache
 	router      *gin.Engine
 }
 
-// NewServer creates a new API server instance
-func NewServer(cfg *config.Config) *Server {
-	// Initialize email sender
-	emailSender := email.NewSender(cfg)
+// Option configures optional server dependencies
+type Option func(*Server)
+
+// WithKeyStore sets the store for API keys managed through the admin API.
+// Without it, managed keys are kept in memory only.
+func WithKeyStore(store *auth.KeyStore) Option {
+	return func(s *Server) {
+		s.keyStore = store
+	}
+}
+
+// WithQuotaTracker sets the tracker for per-key send quotas. Without it,
+// quota usage is kept in memory only.
+func WithQuotaTracker(quota *ratelimit.QuotaTracker) Option {
+	return func(s *Server) {
+		s.quota = quota
+	}
+}
+
+// WithResultStore sets the store for email results. Without it, results
+// are kept as files in the data directory.
+func WithResultStore(results store.Store) Option {
+	return func(s *Server) {
+		s.results = results
+	}
+}
+
+// WithKeyring sets the keys that encrypt debug dumps and decrypt archive
+// bundles. The result store is given its keys when opened. Without it,
+// nothing is encrypted.
+func WithKeyring(keys *encryption.Keyring) Option {
+	return func(s *Server) {
+		s.keys = keys
+	}
+}
 
+// NewServer creates a new API server instance
+func NewServer(cfg *config.Config, opts ...Option) *Server {
 	server := &Server{
-		config:      cfg,
-		emailSender: emailSender,
+		ipLimiter:  ratelimit.NewLimiter(time.Minute),
+		keyLimiter: ratelimit.NewLimiter(time.Minute),
+		nonces:     auth.NewNonceCache(),
+	}
+	server.config.Store(cfg)
+	server.setJWTVerifier(cfg)
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.keyStore == nil {
+		// An in-memory store cannot fail to open
+		server.keyStore, _ = auth.OpenKeyStore("", cfg.APIKeyPepper)
+	}
+	if server.quota == nil {
+		server.quota, _ = ratelimit.OpenQuotaTracker("")
 	}
+	if server.results == nil {
+		server.results = store.OpenFileStore(cfg.DataDir, server.keys)
+	}
+
+	// Initialize email sender
+	server.emailSender = email.NewSender(cfg, server.results, server.keys)
 
 	server.setupRoutes()
 	return server
@@ -33,10 +110,24 @@ func NewServer(cfg *config.Config) *Server {
 
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
-	s.router = gin.Default()
+	s.router = gin.New()
+	if err := s.router.SetTrustedProxies(s.getConfig().TrustedProxies); err != nil {
+		slog.Warn("Ignoring invalid trusted_proxies", "error", err)
+		s.router.SetTrustedProxies(nil)
+	}
 
-	// Health check endpoint
-	s.router.GET("/health", s.getHealth)
+	s.router.Use(requestIDMiddleware(), tracingMiddleware(), s.accessLogMiddleware(), recoveryMiddleware(), metricsMiddleware())
+
+	// Health check endpoints
+	health := s.router.Group("/health", s.ipAllowlistMiddleware())
+	{
+		health.GET("", s.getHealth)
+		health.GET("/live", s.getLiveness)
+		health.GET("/ready", s.getReadiness)
+	}
+
+	// Prometheus metrics
+	s.router.GET("/metrics", s.ipAllowlistMiddleware(), gin.WrapH(metrics.Handler()))
 
 	// API documentation endpoints
 	s.router.GET("/", s.getDocumentation)
@@ -48,56 +139,178 @@ func (s *Server) setupRoutes() {
 	{
 		mail := v1.Group("/mail")
 		{
-			if s.config.IsAPIKeyAuthEnabled() {
-				mail.Use(s.apiKeyAuthMiddleware())
-			}
-			mail.POST("/send", s.sendEmail)
+			mail.Use(s.authMiddleware()...)
+			mail.POST("/send", requireScope(config.ScopeSend), s.sendEmail)
+			mail.GET("/status/:email_id", requireScope(config.ScopeReadStatus), s.getEmailStatus)
+			mail.GET("/usage", requireAuthenticated(), s.getUsage)
+		}
+
+		admin := v1.Group("/admin")
+		{
+			admin.Use(s.authMiddleware()...)
+			admin.Use(requireAuthenticated(), requireScope(config.ScopeAdmin))
+			admin.GET("/keys", s.listAPIKeys)
+			admin.POST("/keys", s.createAPIKey)
+			admin.POST("/keys/:id/rotate", s.rotateAPIKey)
+			admin.DELETE("/keys/:id", s.revokeAPIKey)
+			admin.GET("/usage", s.listUsage)
+			admin.GET("/emails", s.listEmails)
+			admin.GET("/archive", s.listArchiveBundles)
+			admin.GET("/archive/search", s.searchArchive)
+			admin.GET("/archive/emails/:email_id", s.getArchivedEmail)
+			admin.GET("/archive/bundles/:name", s.downloadArchiveBundle)
 		}
 	}
 }
 
+// metricsMiddleware records the duration of every request by route and
+// status. Unmatched paths share one label so that scanners cannot create
+// unbounded series.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// getConfig returns the current configuration snapshot
+func (s *Server) getConfig() *config.Config {
+	return s.config.Load()
+}
+
+// Reload atomically replaces the configuration used by the server and its
+// email sender. Requests and sends already in progress keep the
+// configuration they started with. Listener, TLS, trusted proxy, storage,
+// encryption and tracing settings only take effect after a restart; client
+// certificate mappings and IP allowlists apply immediately.
+func (s *Server) Reload(cfg *config.Config) {
+	old := s.config.Swap(cfg)
+	s.emailSender.SetConfig(cfg)
+	if !reflect.DeepEqual(old.JWT, cfg.JWT) {
+		s.setJWTVerifier(cfg)
+	}
+
+	oldNetwork, oldAddr := old.ListenAddress()
+	newNetwork, newAddr := cfg.ListenAddress()
+	if oldNetwork != newNetwork || oldAddr != newAddr {
+		slog.Warn("Listen address changed; restart to apply", "network", newNetwork, "address", newAddr)
+	}
+	if !reflect.DeepEqual(old.TrustedProxies, cfg.TrustedProxies) {
+		slog.Warn("Trusted proxies changed; restart to apply")
+	}
+	if old.TLS.CertFile != cfg.TLS.CertFile || old.TLS.KeyFile != cfg.TLS.KeyFile ||
+		old.TLS.ClientCAFile != cfg.TLS.ClientCAFile || old.TLS.RequireClientCert != cfg.TLS.RequireClientCert {
+		slog.Warn("TLS settings changed; restart to apply")
+	}
+	if old.Storage != cfg.Storage || old.DataDir != cfg.DataDir {
+		slog.Warn("Storage settings changed; restart to apply")
+	}
+	if !reflect.DeepEqual(old.Encryption, cfg.Encryption) {
+		slog.Warn("Encryption keys changed; restart to apply")
+	}
+	if !reflect.DeepEqual(old.Tracing, cfg.Tracing) {
+		slog.Warn("Tracing settings changed; restart to apply")
+	}
+}
+
+// setJWTVerifier replaces the bearer token verifier, dropping cached keys.
+// It is cleared when JWT authentication is disabled.
+func (s *Server) setJWTVerifier(cfg *config.Config) {
+	if !cfg.IsJWTAuthEnabled() {
+		s.jwtVerifier.Store(nil)
+		return
+	}
+	s.jwtVerifier.Store(auth.NewJWTVerifier(cfg.JWT))
+}
+
 // GetRouter returns the router for testing purposes
 func (s *Server) GetRouter() *gin.Engine {
 	return s.router
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server on the configured TCP address or Unix socket
 func (s *Server) Start() error {
-	port := ":8000"
-	fmt.Printf("Starting server on port %s\n", port)
-	return s.router.Run(port)
-}
+	network, addr := s.getConfig().ListenAddress()
 
-// apiKeyAuthMiddleware validates API key if authentication is enabled
-func (s *Server) apiKeyAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey != s.config.APIKey {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "Could not validate credentials",
-			})
-			c.Abort()
-			return
+	if network == "unix" {
+		// Remove a stale socket left behind by a previous run
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(addr); err != nil {
+				return fmt.Errorf("failed to remove stale socket %s: %w", addr, err)
+			}
 		}
-		c.Next()
 	}
+
+	tlsConfig, err := s.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s %s: %w", network, addr, err)
+	}
+
+	srv := &http.Server{Handler: s.router, TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		slog.Info("Starting HTTPS server", "network", network, "address", addr)
+		return srv.ServeTLS(listener, "", "")
+	}
+	slog.Info("Starting server", "network", network, "address", addr)
+	return srv.Serve(listener)
 }
 
-// getHealth handles health check requests
-func (s *Server) getHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"timestamp": c.Request.Header.Get("X-Request-Time"),
-		"version":   "1.0.0",
-	})
+// TLSConfig builds the HTTPS settings from the configuration, or returns
+// nil when TLS is disabled. With a client CA, client certificates are
+// verified against it and required if require_client_cert is set.
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	cfg := s.getConfig()
+	if !cfg.IsTLSEnabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", cfg.TLS.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.TLS.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
 }
 
 // getDocumentation serves the Swagger UI documentation
 func (s *Server) getDocumentation(c *gin.Context) {
+	cfg := s.getConfig()
 	html := `<!DOCTYPE html>
 <html>
 <head>
-    <title>` + s.config.APIName + ` - Swagger UI</title>
+    <title>` + cfg.APIName + ` - Swagger UI</title>
     <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@3.52.5/swagger-ui.css" />
 </head>
 <body>
@@ -120,12 +333,13 @@ func (s *Server) getDocumentation(c *gin.Context) {
 
 // getOpenAPISpec returns the OpenAPI specification
 func (s *Server) getOpenAPISpec(c *gin.Context) {
+	cfg := s.getConfig()
 	spec := map[string]interface{}{
 		"openapi": "3.0.0",
 		"info": map[string]interface{}{
-			"title":       s.config.APIName,
-			"description": s.config.APIDescription,
-			"version":     "1.0.0",
+			"title":       cfg.APIName,
+			"description": cfg.APIDescription,
+			"version":     version.Version,
 		},
 		"paths": map[string]interface{}{
 			"/v1/mail/send": map[string]interface{}{
@@ -146,6 +360,31 @@ func (s *Server) getOpenAPISpec(c *gin.Context) {
 						"200": map[string]interface{}{
 							"description": "Email queued successfully",
 						},
+						"403": map[string]interface{}{
+							"description": "Caller may not use the sender identity",
+						},
+					},
+				},
+			},
+			"/v1/mail/status/{email_id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Get email status",
+					"description": "Get the stored result of an email (requires the read-status scope)",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "email_id",
+							"in":       "path",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "string", "format": "uuid"},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Email result",
+						},
+						"404": map[string]interface{}{
+							"description": "Email not found",
+						},
 					},
 				},
 			},
@@ -180,31 +419,53 @@ func (s *Server) getOpenAPISpec(c *gin.Context) {
 							"default":     false,
 							"description": "Enable debug mode",
 						},
+						"from": map[string]interface{}{
+							"type":        "string",
+							"format":      "email",
+							"description": "Address of the sender identity to send as",
+						},
+						"sender_id": map[string]interface{}{
+							"type":        "string",
+							"description": "ID of the sender identity to send as (default identity if omitted)",
+						},
 					},
 				},
 			},
 			"securitySchemes": func() map[string]interface{} {
-				if s.config.IsAPIKeyAuthEnabled() {
-					return map[string]interface{}{
-						"ApiKeyAuth": map[string]interface{}{
-							"type": "apiKey",
-							"in":   "header",
-							"name": "X-API-Key",
-						},
+				schemes := map[string]interface{}{}
+				if cfg.IsAPIKeyAuthEnabled() {
+					schemes["ApiKeyAuth"] = map[string]interface{}{
+						"type": "apiKey",
+						"in":   "header",
+						"name": "X-API-Key",
 					}
 				}
-				return map[string]interface{}{}
+				if cfg.IsJWTAuthEnabled() {
+					schemes["BearerAuth"] = map[string]interface{}{
+						"type":         "http",
+						"scheme":       "bearer",
+						"bearerFormat": "JWT",
+					}
+				}
+				return schemes
 			}(),
 		},
 		"security": func() []interface{} {
-			if s.config.IsAPIKeyAuthEnabled() {
-				return []interface{}{
-					map[string]interface{}{
-						"ApiKeyAuth": []interface{}{},
-					},
-				}
+			var security []interface{}
+			if cfg.IsAPIKeyAuthEnabled() {
+				security = append(security, map[string]interface{}{
+					"ApiKeyAuth": []interface{}{},
+				})
+			}
+			if cfg.IsJWTAuthEnabled() {
+				security = append(security, map[string]interface{}{
+					"BearerAuth": []interface{}{},
+				})
+			}
+			if security == nil {
+				return []interface{}{}
 			}
-			return []interface{}{}
+			return security
 		}(),
 	}
 
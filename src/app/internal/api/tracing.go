@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingMiddleware starts a server span for every request, continuing the
+// trace of an incoming traceparent header, and adds the trace ID to the
+// request logger
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request_id", c.Writer.Header().Get(requestIDHeader)),
+			),
+		)
+		defer span.End()
+
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", traceID))
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
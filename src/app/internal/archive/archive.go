@@ -0,0 +1,327 @@
+// Package archive rolls finished days of email results into gzip-compressed
+// JSON Lines bundles, one per day, and searches them. With a keyring the
+// compressed bundles are sealed.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+)
+
+// dateLayout names the bundles, <date>.jsonl.gz
+const dateLayout = "2006-01-02"
+
+// bundleSuffix ends every bundle name
+const bundleSuffix = ".jsonl.gz"
+
+// Bundle describes one archive file
+type Bundle struct {
+	Day  string `json:"day"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Days archives every day before cutoff, oldest first: the day's results
+// are written to a bundle in dir, encrypted with keys unless it is nil, and
+// then removed from results. It returns how many results were archived.
+func Days(ctx context.Context, results store.Store, dir string, keys *encryption.Keyring, cutoff time.Time) (int, error) {
+	archived := 0
+	lastOldest := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return archived, err
+		}
+		oldest, err := results.List(ctx, store.Query{OldestFirst: true, Limit: 1})
+		if err != nil {
+			return archived, err
+		}
+		if len(oldest) == 0 {
+			return archived, nil
+		}
+
+		t := store.ResultTime(oldest[0])
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		end := start.AddDate(0, 0, 1)
+		if end.After(cutoff) {
+			return archived, nil
+		}
+		// A result the day's removal did not reach would be archived forever
+		if oldest[0].EmailID == lastOldest {
+			return archived, fmt.Errorf("result %s was archived but could not be removed", lastOldest)
+		}
+		lastOldest = oldest[0].EmailID
+
+		// Everything before end is removed below, so everything before end
+		// goes into the bundle
+		day, err := results.List(ctx, store.Query{Until: end, OldestFirst: true})
+		if err != nil {
+			return archived, err
+		}
+		if _, err := writeBundle(dir, keys, start, day); err != nil {
+			return archived, err
+		}
+		for _, status := range []string{"success", "failure"} {
+			if _, err := results.Prune(ctx, status, end); err != nil {
+				return archived, err
+			}
+		}
+		archived += len(day)
+	}
+}
+
+// writeBundle writes results to the bundle of day. An existing bundle for
+// the day is kept and a new one written next to it.
+func writeBundle(dir string, keys *encryption.Keyring, day time.Time, results []*models.EmailResult) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	name := day.Format(dateLayout) + bundleSuffix
+	if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+		name = fmt.Sprintf("%s.%d%s", day.Format(dateLayout), time.Now().UnixNano(), bundleSuffix)
+	}
+
+	// The day's results are already in memory, so the bundle is built
+	// there too and sealed whole
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, result := range results {
+		if err := enc.Encode(result); err != nil {
+			return "", fmt.Errorf("failed to write archive bundle: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+	data, err := keys.Seal(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt archive bundle: %w", err)
+	}
+
+	// Write to a temporary file so that a crash never leaves a partial
+	// bundle in place of the results it replaces
+	tmp, err := os.CreateTemp(dir, ".bundle-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive bundle: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+	return path, nil
+}
+
+// List returns the bundles in dir, newest day first
+func List(dir string) ([]Bundle, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Bundle{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	bundles := []Bundle{}
+	for _, entry := range entries {
+		day, ok := bundleDay(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		bundles = append(bundles, Bundle{Day: day, Name: entry.Name(), Size: info.Size()})
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		if bundles[i].Day != bundles[j].Day {
+			return bundles[i].Day > bundles[j].Day
+		}
+		return bundles[i].Name > bundles[j].Name
+	})
+	return bundles, nil
+}
+
+// Search returns the archived results matching q, newest day first. Only
+// the bundles of days in the query's range are read.
+func Search(ctx context.Context, dir string, keys *encryption.Keyring, q store.Query) ([]*models.EmailResult, error) {
+	bundles, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*models.EmailResult{}
+	for _, bundle := range bundles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !dayInRange(bundle.Day, q) {
+			continue
+		}
+
+		var day []*models.EmailResult
+		err := readBundle(filepath.Join(dir, bundle.Name), keys, func(result *models.EmailResult) bool {
+			if q.Matches(result, store.ResultTime(result)) {
+				day = append(day, result)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(day, func(i, j int) bool {
+			return store.ResultTime(day[i]).After(store.ResultTime(day[j]))
+		})
+		results = append(results, day...)
+		if q.Limit > 0 && len(results) >= q.Limit {
+			return results[:q.Limit], nil
+		}
+	}
+	return results, nil
+}
+
+// Extract returns one archived result. day, if given, limits the search to
+// that day's bundles.
+func Extract(ctx context.Context, dir string, keys *encryption.Keyring, emailID, day string) (*models.EmailResult, error) {
+	bundles, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bundle := range bundles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if day != "" && bundle.Day != day {
+			continue
+		}
+
+		var found *models.EmailResult
+		err := readBundle(filepath.Join(dir, bundle.Name), keys, func(result *models.EmailResult) bool {
+			if result.EmailID == emailID {
+				found = result
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Prune removes the bundles of days before before and returns how many
+// were removed
+func Prune(dir string, before time.Time) (int, error) {
+	bundles, err := List(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, bundle := range bundles {
+		start, _ := time.ParseInLocation(dateLayout, bundle.Day, time.Local)
+		if start.AddDate(0, 0, 1).After(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, bundle.Name)); err != nil {
+			return removed, fmt.Errorf("failed to remove expired archive bundle: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// readBundle calls fn for each result in a bundle until fn returns false
+func readBundle(path string, keys *encryption.Keyring, fn func(*models.EmailResult) bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive bundle: %w", err)
+	}
+	if data, err = keys.Open(data); err != nil {
+		return fmt.Errorf("failed to decrypt archive bundle %s: %w", filepath.Base(path), err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to read archive bundle %s: %w", filepath.Base(path), err)
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var result models.EmailResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return fmt.Errorf("failed to parse archive bundle %s: %w", filepath.Base(path), err)
+		}
+		if !fn(&result) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read archive bundle %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// bundleDay returns the day of a bundle name
+func bundleDay(name string) (string, bool) {
+	if !strings.HasSuffix(name, bundleSuffix) || len(name) < len(dateLayout) {
+		return "", false
+	}
+	day := name[:len(dateLayout)]
+	if _, err := time.Parse(dateLayout, day); err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// dayInRange reports whether a day may hold results in the query's time
+// range, allowing for results recorded in another time zone
+func dayInRange(day string, q store.Query) bool {
+	start, err := time.Parse(dateLayout, day)
+	if err != nil {
+		return false
+	}
+	if !q.Since.IsZero() && start.Add(48*time.Hour).Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && start.Add(-24*time.Hour).After(q.Until) {
+		return false
+	}
+	return true
+}
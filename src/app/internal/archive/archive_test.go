@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDays(t *testing.T) {
+	ctx := context.Background()
+	results := store.OpenFileStore(t.TempDir(), nil)
+	dir := t.TempDir()
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	save := func(id, status, recipient string, at time.Time) {
+		assert.NoError(t, results.Save(ctx, &models.EmailResult{
+			EmailID: id, Status: status, Recipient: recipient, Timestamp: at.Format(time.RFC3339),
+		}))
+	}
+	save("a", "success", "x@example.com", today.AddDate(0, 0, -3).Add(9*time.Hour))
+	save("b", "failure", "y@example.com", today.AddDate(0, 0, -3).Add(10*time.Hour))
+	save("c", "success", "x@example.com", today.AddDate(0, 0, -1).Add(time.Hour))
+	save("d", "success", "x@example.com", today.Add(time.Minute))
+
+	archived, err := Days(ctx, results, dir, nil, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, archived)
+
+	// Test only today's result is left in the store
+	left, err := results.List(ctx, store.Query{})
+	assert.NoError(t, err)
+	if assert.Len(t, left, 1) {
+		assert.Equal(t, "d", left[0].EmailID)
+	}
+
+	// Test one bundle is written per finished day
+	bundles, err := List(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, bundles, 2) {
+		assert.Equal(t, today.AddDate(0, 0, -1).Format("2006-01-02"), bundles[0].Day)
+		assert.Equal(t, today.AddDate(0, 0, -3).Format("2006-01-02")+".jsonl.gz", bundles[1].Name)
+	}
+
+	// Test archived results can be searched and extracted
+	found, err := Search(ctx, dir, nil, store.Query{Recipient: "x@example.com"})
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "c", found[0].EmailID)
+		assert.Equal(t, "a", found[1].EmailID)
+	}
+	found, err = Search(ctx, dir, nil, store.Query{Status: "failure"})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	result, err := Extract(ctx, dir, nil, "b", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "y@example.com", result.Recipient)
+	_, err = Extract(ctx, dir, nil, "b", bundles[0].Day)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// Test running again archives nothing more
+	archived, err = Days(ctx, results, dir, nil, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, archived)
+
+	// Test expired bundles are removed
+	removed, err := Prune(dir, today.AddDate(0, 0, -2))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	bundles, _ = List(dir)
+	assert.Len(t, bundles, 1)
+}
+
+func TestWriteBundleKeepsExisting(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.Local)
+
+	first, err := writeBundle(dir, nil, day, []*models.EmailResult{{EmailID: "a"}})
+	assert.NoError(t, err)
+	second, err := writeBundle(dir, nil, day, []*models.EmailResult{{EmailID: "b"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	for _, id := range []string{"a", "b"} {
+		_, err := Extract(context.Background(), dir, nil, id, "2024-05-10")
+		assert.NoError(t, err, id)
+	}
+
+	// Test no temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+	_, ok := bundleDay(filepath.Base(second))
+	assert.True(t, ok)
+}
+
+func TestEncryptedBundle(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := encryption.New(config.Encryption{
+		Keys:       []config.EncryptionKey{{ID: "k1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}},
+		PrimaryKey: "k1",
+	})
+	assert.NoError(t, err)
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.Local)
+
+	path, err := writeBundle(dir, keys, day, []*models.EmailResult{{EmailID: "a", Recipient: "secret@example.com"}})
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(path)
+	assert.True(t, encryption.IsSealed(data))
+
+	result, err := Extract(context.Background(), dir, keys, "a", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret@example.com", result.Recipient)
+
+	// Test the bundle cannot be read without the keys
+	_, err = Search(context.Background(), dir, nil, store.Query{})
+	assert.ErrorIs(t, err, encryption.ErrNoKeys)
+}
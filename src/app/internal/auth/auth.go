@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// Errors returned when an API key cannot be used
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrExpiredAPIKey = errors.New("API key has expired")
+
+	// ErrSignatureRequired is returned when a key that must sign requests
+	// is sent as a static header
+	ErrSignatureRequired = errors.New("API key requires signed requests")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Name identifies the caller, e.g. the API key name
+	Name   string
+	Scopes []string
+
+	// Sender identities the caller may use (empty means all)
+	AllowedSenders []string
+
+	// Client IP ranges the caller may connect from (empty means all)
+	AllowedIPs []string
+
+	// Overrides of the default rate limit and quotas (zero keeps the default)
+	RateLimitPerMinute int
+	DailyQuota         int
+	MonthlyQuota       int
+}
+
+// Anonymous is the principal used when authentication is disabled. It has
+// every scope and may use every sender identity.
+var Anonymous = &Principal{Scopes: config.Scopes}
+
+// HasScope reports whether the principal was granted scope. The admin scope
+// implies every other scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == config.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanUseSender reports whether the principal may send as the identity
+func (p *Principal) CanUseSender(id string) bool {
+	if len(p.AllowedSenders) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedSenders {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CanConnectFrom reports whether the principal may be used from the client
+// IP address
+func (p *Principal) CanConnectFrom(ip string) bool {
+	return config.IPAllowed(p.AllowedIPs, ip)
+}
+
+// MatchAPIKey finds the key equal to presented and returns its principal.
+// Every key is compared in constant time so the response time does not
+// reveal how much of a key matched or which key it was.
+func MatchAPIKey(keys []config.APIKey, presented string, now time.Time) (*Principal, error) {
+	presentedSum := sha256.Sum256([]byte(presented))
+
+	var match *config.APIKey
+	for i := range keys {
+		sum := sha256.Sum256([]byte(keys[i].Key))
+		if subtle.ConstantTimeCompare(sum[:], presentedSum[:]) == 1 {
+			match = &keys[i]
+		}
+	}
+
+	if match == nil || presented == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	if match.RequireSignature {
+		return nil, ErrSignatureRequired
+	}
+	if match.Expired(now) {
+		return nil, ErrExpiredAPIKey
+	}
+
+	return principalFromKey(match), nil
+}
+
+// principalFromKey returns the principal authenticated by a configured key
+func principalFromKey(key *config.APIKey) *Principal {
+	return &Principal{
+		Name:               key.Name,
+		Scopes:             key.Scopes,
+		AllowedSenders:     key.AllowedSenders,
+		AllowedIPs:         key.AllowedIPs,
+		RateLimitPerMinute: key.RateLimitPerMinute,
+		DailyQuota:         key.DailyQuota,
+		MonthlyQuota:       key.MonthlyQuota,
+	}
+}
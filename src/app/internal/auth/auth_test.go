@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchAPIKey(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	keys := []config.APIKey{
+		{Name: "billing", Key: "billing-key", Scopes: []string{config.ScopeSend}, AllowedSenders: []string{"billing"}},
+		{Name: "old", Key: "old-key", Scopes: []string{config.ScopeSend}, ExpiresAt: &expired},
+	}
+
+	// Test a valid key returns its principal
+	principal, err := MatchAPIKey(keys, "billing-key", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", principal.Name)
+	assert.True(t, principal.HasScope(config.ScopeSend))
+	assert.False(t, principal.HasScope(config.ScopeReadStatus))
+	assert.True(t, principal.CanUseSender("billing"))
+	assert.False(t, principal.CanUseSender("alerts"))
+
+	// Test unknown, empty and expired keys are rejected
+	_, err = MatchAPIKey(keys, "billing-kez", now)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = MatchAPIKey([]config.APIKey{{Name: "empty"}}, "", now)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = MatchAPIKey(keys, "old-key", now)
+	assert.ErrorIs(t, err, ErrExpiredAPIKey)
+}
+
+func TestAdminScopeImpliesAll(t *testing.T) {
+	principal := &Principal{Scopes: []string{config.ScopeAdmin}}
+	for _, scope := range config.Scopes {
+		assert.True(t, principal.HasScope(scope))
+	}
+	assert.True(t, Anonymous.HasScope(config.ScopeAdmin))
+	assert.True(t, Anonymous.CanUseSender("anything"))
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	keys := []config.APIKey{{Name: "billing", Key: "secret", Scopes: []string{config.ScopeSend}}}
+	body := []byte(`{"subject": "Hi"}`)
+
+	signed := func(timestamp, nonce string) *SignedRequest {
+		req := &SignedRequest{
+			Method:        "POST",
+			RequestURI:    "/v1/mail/send",
+			Body:          body,
+			KeyID:         "billing",
+			Timestamp:     timestamp,
+			Nonce:         nonce,
+			ContentSHA256: BodyDigest(body),
+		}
+		req.Signature = Sign("secret", StringToSign(req.Method, req.RequestURI, req.Timestamp, req.Nonce, req.ContentSHA256))
+		return req
+	}
+	nonces := NewNonceCache()
+
+	// Test a valid signature
+	principal, err := VerifySignature(keys, signed("1767225600", "n1"), now, 5*time.Minute, nonces)
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", principal.Name)
+
+	// Test replays are rejected
+	_, err = VerifySignature(keys, signed("1767225600", "n1"), now, 5*time.Minute, nonces)
+	assert.ErrorIs(t, err, ErrReplayedNonce)
+
+	// Test stale timestamps are rejected
+	_, err = VerifySignature(keys, signed("1767225000", "n2"), now, 5*time.Minute, nonces)
+	assert.ErrorIs(t, err, ErrStaleTimestamp)
+
+	// Test tampering with the body or path is detected
+	req := signed("1767225600", "n3")
+	req.Body = []byte(`{"subject": "Changed"}`)
+	_, err = VerifySignature(keys, req, now, 5*time.Minute, nonces)
+	assert.ErrorIs(t, err, ErrBodyDigest)
+
+	req = signed("1767225600", "n4")
+	req.RequestURI = "/v1/admin/keys"
+	_, err = VerifySignature(keys, req, now, 5*time.Minute, nonces)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// Test unknown keys are rejected
+	req = signed("1767225600", "n5")
+	req.KeyID = "other"
+	_, err = VerifySignature(keys, req, now, 5*time.Minute, nonces)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestNonceCacheSweep(t *testing.T) {
+	nonces := NewNonceCache()
+	now := time.Now()
+
+	assert.True(t, nonces.Use("a", now.Add(time.Second), now))
+	assert.False(t, nonces.Use("a", now.Add(time.Second), now))
+
+	// Test expired nonces are kept until the next sweep, then dropped
+	later := now.Add(2 * time.Second)
+	assert.True(t, nonces.Use("b", later.Add(time.Hour), later))
+	assert.Len(t, nonces.nonces, 2)
+	later = now.Add(nonceSweepInterval)
+	assert.True(t, nonces.Use("c", later.Add(time.Hour), later))
+	assert.Len(t, nonces.nonces, 2)
+	assert.NotContains(t, nonces.nonces, "a")
+
+	// Test an expired nonce may be used again
+	assert.True(t, nonces.Use("a", later.Add(time.Second), later))
+}
+
+func TestRequireSignature(t *testing.T) {
+	keys := []config.APIKey{{Name: "signer", Key: "secret", RequireSignature: true}}
+	_, err := MatchAPIKey(keys, "secret", time.Now())
+	assert.ErrorIs(t, err, ErrSignatureRequired)
+}
+
+func TestMatchClientCert(t *testing.T) {
+	certs := []config.ClientCert{
+		{Subject: "billing", Scopes: []string{config.ScopeSend}, AllowedSenders: []string{"billing"}},
+		{Subject: "CN=reports,O=Example", Name: "reporting", Scopes: []string{config.ScopeReadStatus}, AllowedIPs: []string{"10.0.0.0/8"}, DailyQuota: 10},
+	}
+
+	// Test matching by common name
+	p, err := MatchClientCert(certs, &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Example"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "cert:billing", p.Name)
+	assert.Equal(t, []string{"billing"}, p.AllowedSenders)
+
+	// Test matching by distinguished name
+	p, err = MatchClientCert(certs, &x509.Certificate{Subject: pkix.Name{CommonName: "reports", Organization: []string{"Example"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "cert:reporting", p.Name)
+	assert.True(t, p.HasScope(config.ScopeReadStatus))
+	assert.True(t, p.CanConnectFrom("10.1.2.3"))
+	assert.False(t, p.CanConnectFrom("192.0.2.1"))
+	assert.Equal(t, 10, p.DailyQuota)
+
+	// Test an unmapped subject
+	_, err = MatchClientCert(certs, &x509.Certificate{Subject: pkix.Name{CommonName: "reports", Organization: []string{"Other"}}})
+	assert.ErrorIs(t, err, ErrUnknownClientCert)
+}
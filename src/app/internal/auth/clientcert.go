@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// ErrUnknownClientCert is returned when a verified client certificate does
+// not match any configured subject
+var ErrUnknownClientCert = errors.New("client certificate is not mapped to a principal")
+
+// MatchClientCert returns the principal mapped to the subject of a client
+// certificate that has already been verified against the client CA
+func MatchClientCert(certs []config.ClientCert, cert *x509.Certificate) (*Principal, error) {
+	for i := range certs {
+		if certs[i].Matches(cert) {
+			return &Principal{
+				Name:               certs[i].PrincipalName(),
+				Scopes:             certs[i].Scopes,
+				AllowedSenders:     certs[i].AllowedSenders,
+				AllowedIPs:         certs[i].AllowedIPs,
+				RateLimitPerMinute: certs[i].RateLimitPerMinute,
+				DailyQuota:         certs[i].DailyQuota,
+				MonthlyQuota:       certs[i].MonthlyQuota,
+			}, nil
+		}
+	}
+	return nil, ErrUnknownClientCert
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned when a bearer token cannot be verified
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// jwtMethods are the accepted signing algorithms. Symmetric algorithms and
+// "none" are never accepted.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwksMinRefetch limits how often an unknown key ID triggers a fetch
+const jwksMinRefetch = time.Minute
+
+// JWTVerifier verifies bearer tokens against a JSON Web Key Set and maps
+// their claims to a principal
+type JWTVerifier struct {
+	cfg  config.JWTAuth
+	jwks *jwks
+}
+
+// NewJWTVerifier creates a verifier. The key set is loaded on first use.
+func NewJWTVerifier(cfg config.JWTAuth) *JWTVerifier {
+	return &JWTVerifier{
+		cfg: cfg,
+		jwks: &jwks{
+			file:    cfg.JWKSFile,
+			url:     cfg.JWKSURL,
+			refresh: time.Duration(cfg.RefreshInterval) * time.Second,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		},
+	}
+}
+
+// Verify checks the token signature, expiry, issuer and audience, and
+// returns the principal described by its claims. The principal is named
+// after the name claim with the jwt: prefix.
+func (v *JWTVerifier) Verify(token string, now time.Time) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.key(kid, now)
+	},
+		jwt.WithValidMethods(jwtMethods),
+		jwt.WithIssuer(v.cfg.Issuer),
+		jwt.WithAudience(v.cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	name, _ := claims[v.cfg.NameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, v.cfg.NameClaim)
+	}
+
+	return &Principal{
+		Name:           config.JWTPrincipalPrefix + name,
+		Scopes:         v.mapScopes(claimStrings(claims[v.cfg.ScopeClaim])),
+		AllowedSenders: claimStrings(claims[v.cfg.SendersClaim]),
+	}, nil
+}
+
+// mapScopes converts token scopes to API scopes, dropping unknown ones
+func (v *JWTVerifier) mapScopes(tokenScopes []string) []string {
+	var scopes []string
+	for _, s := range tokenScopes {
+		if len(v.cfg.ScopeMapping) > 0 {
+			scopes = append(scopes, v.cfg.ScopeMapping[s]...)
+		} else if config.ValidScope(s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// claimStrings reads a claim given either as a space-separated string, as
+// OAuth does for "scope", or as a list of strings
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// jwks holds the public keys of a JSON Web Key Set, loaded from a file or
+// URL and refreshed periodically or when an unknown key ID appears
+type jwks struct {
+	file    string
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// key returns the public key with the given ID. An empty ID is accepted
+// when the set holds a single key.
+func (j *jwks) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stale := j.keys == nil || (j.url != "" && now.Sub(j.fetchedAt) >= j.refresh)
+	_, known := j.keys[kid]
+	if stale || (!known && now.Sub(j.fetchedAt) >= jwksMinRefetch) {
+		if err := j.load(now); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, nil
+		}
+	}
+	if k, ok := j.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// load reads and parses the key set. The caller must hold the lock. On
+// failure the previous keys are kept.
+func (j *jwks) load(now time.Time) error {
+	j.fetchedAt = now
+
+	var data []byte
+	var err error
+	if j.file != "" {
+		data, err = os.ReadFile(j.file)
+	} else {
+		data, err = j.fetch()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	j.keys = keys
+	return nil
+}
+
+// fetch downloads the key set from its URL
+func (j *jwks) fetch() ([]byte, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, j.url)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jsonWebKey is a public key in JWK format (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the RSA, EC and Ed25519 signing keys of a key set.
+// Keys of other types or for encryption are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey converts a JWK to a Go public key, or nil for unsupported types
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// decodeBigInt decodes a base64url big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeJWKS writes a key set holding the public half of key
+func writeJWKS(t *testing.T, key *rsa.PrivateKey, kid string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	assert.NoError(t, err)
+	return data
+}
+
+func signToken(t *testing.T, key interface{}, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestJWTVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, writeJWKS(t, key, "k1"), 0644))
+
+	verifier := NewJWTVerifier(config.JWTAuth{
+		JWKSFile:     path,
+		Issuer:       "https://idp.example.com",
+		Audience:     "smtogo",
+		NameClaim:    "sub",
+		ScopeClaim:   "scope",
+		SendersClaim: "allowed_senders",
+		ScopeMapping: map[string][]string{
+			"mail:send": {config.ScopeSend},
+			"mail:read": {config.ScopeReadStatus},
+		},
+	})
+	now := time.Now()
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":             "https://idp.example.com",
+			"aud":             "smtogo",
+			"sub":             "billing-service",
+			"exp":             now.Add(time.Hour).Unix(),
+			"scope":           "mail:send profile",
+			"allowed_senders": []string{"billing"},
+		}
+	}
+
+	// Test a valid token maps its claims
+	p, err := verifier.Verify(signToken(t, key, jwt.SigningMethodRS256, "k1", claims()), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "jwt:billing-service", p.Name)
+	assert.Equal(t, []string{config.ScopeSend}, p.Scopes)
+	assert.Equal(t, []string{"billing"}, p.AllowedSenders)
+
+	// Test rejected tokens
+	wrongIssuer := claims()
+	wrongIssuer["iss"] = "https://other.example.com"
+	wrongAudience := claims()
+	wrongAudience["aud"] = "other"
+	expired := claims()
+	expired["exp"] = now.Add(-time.Hour).Unix()
+	noExpiry := claims()
+	delete(noExpiry, "exp")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	for name, token := range map[string]string{
+		"issuer":    signToken(t, key, jwt.SigningMethodRS256, "k1", wrongIssuer),
+		"audience":  signToken(t, key, jwt.SigningMethodRS256, "k1", wrongAudience),
+		"expired":   signToken(t, key, jwt.SigningMethodRS256, "k1", expired),
+		"no expiry": signToken(t, key, jwt.SigningMethodRS256, "k1", noExpiry),
+		"other key": signToken(t, otherKey, jwt.SigningMethodRS256, "k1", claims()),
+		"hmac":      signToken(t, []byte("secret"), jwt.SigningMethodHS256, "k1", claims()),
+		"garbage":   "not.a.token",
+	} {
+		_, err := verifier.Verify(token, now)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestJWTVerifierScopes(t *testing.T) {
+	verifier := NewJWTVerifier(config.JWTAuth{})
+	assert.Equal(t, []string{config.ScopeSend, config.ScopeReadStatus},
+		verifier.mapScopes(claimStrings([]interface{}{"send", "read-status", "openid"})))
+	assert.Nil(t, verifier.mapScopes(claimStrings(42)))
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	current := writeJWKS(t, oldKey, "old")
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(current)
+	}))
+	defer srv.Close()
+
+	set := &jwks{url: srv.URL, refresh: time.Hour, client: srv.Client()}
+	now := time.Now()
+
+	_, err = set.key("old", now)
+	assert.NoError(t, err)
+	_, err = set.key("old", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetches)
+
+	// Test an unknown key ID triggers a refetch after a key rotation
+	current = writeJWKS(t, newKey, "new")
+	_, err = set.key("new", now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	// Test unknown key IDs do not refetch more than once a minute
+	_, err = set.key("missing", now.Add(2*time.Minute+time.Second))
+	assert.Error(t, err)
+	assert.Equal(t, 2, fetches)
+
+	// Test the set is refreshed after the interval
+	_, err = set.key("new", now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fetches)
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by the key store
+var (
+	ErrKeyNotFound = errors.New("API key not found")
+	ErrKeyRevoked  = errors.New("API key has been revoked")
+)
+
+// keySecretPrefix marks generated secrets so they are easy to spot in
+// secret scanners
+const keySecretPrefix = "smtogo_"
+
+// StoredKey is an API key managed through the admin API. Only a hash of the
+// secret is kept.
+type StoredKey struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Hash           string     `json:"hash"`
+	Hint           string     `json:"hint"`
+	Scopes         []string   `json:"scopes"`
+	AllowedSenders []string   `json:"allowed_senders"`
+	AllowedIPs     []string   `json:"allowed_ips,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RotatedAt      *time.Time `json:"rotated_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+
+	// Overrides of the rate_limit defaults (zero keeps the default)
+	RateLimitPerMinute int `json:"rate_limit_per_minute,omitempty"`
+	DailyQuota         int `json:"daily_quota,omitempty"`
+	MonthlyQuota       int `json:"monthly_quota,omitempty"`
+}
+
+// KeyStore keeps managed API keys, persisted as JSON when a path is given.
+// Secrets are hashed with HMAC-SHA256 keyed by a pepper that lives outside
+// the store, so a leaked store file alone does not allow guessing keys.
+type KeyStore struct {
+	mu     sync.RWMutex
+	path   string
+	pepper []byte
+	keys   []StoredKey
+}
+
+// pepperSize is the number of random bytes in a generated pepper
+const pepperSize = 32
+
+// OpenKeyStore loads the key store at path, which need not exist yet. An
+// empty path gives a store that is only kept in memory, hashed with a
+// random pepper when none is given.
+func OpenKeyStore(path, pepper string) (*KeyStore, error) {
+	s := &KeyStore{path: path, pepper: []byte(pepper)}
+	if path == "" {
+		if pepper == "" {
+			s.pepper = make([]byte, pepperSize)
+			if _, err := rand.Read(s.pepper); err != nil {
+				return nil, fmt.Errorf("failed to generate pepper: %w", err)
+			}
+		}
+		return s, nil
+	}
+	if pepper == "" {
+		return nil, errors.New("a pepper is required for a persistent key store")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, fmt.Errorf("failed to parse key store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Create adds a key with the name, permissions, limits and expiry of
+// template and returns its secret, which is not stored and cannot be
+// retrieved again
+func (s *KeyStore) Create(template StoredKey) (string, *StoredKey, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := template
+	key.ID = uuid.New().String()
+	key.Hash = s.hash(secret)
+	key.Hint = secretHint(secret)
+	key.CreatedAt = time.Now().UTC()
+	key.RotatedAt = nil
+	key.RevokedAt = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", nil, err
+	}
+	return secret, &key, nil
+}
+
+// List returns every stored key, including revoked ones
+func (s *KeyStore) List() []StoredKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]StoredKey(nil), s.keys...)
+}
+
+// HasName reports whether any stored key uses name. Revoked keys count,
+// since quotas and result ownership are keyed by name.
+func (s *KeyStore) HasName(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the store holds any key that is not revoked
+func (s *KeyStore) Active() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.RevokedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Rotate replaces the secret of a key and returns the new one. The old
+// secret stops working immediately.
+func (s *KeyStore) Rotate(id string) (string, *StoredKey, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.find(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	old := s.keys[i]
+	now := time.Now().UTC()
+	s.keys[i].Hash = s.hash(secret)
+	s.keys[i].Hint = secretHint(secret)
+	s.keys[i].RotatedAt = &now
+	if err := s.save(); err != nil {
+		s.keys[i] = old
+		return "", nil, err
+	}
+	key := s.keys[i]
+	return secret, &key, nil
+}
+
+// Revoke disables a key. The record is kept for auditing.
+func (s *KeyStore) Revoke(id string) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	s.keys[i].RevokedAt = &now
+	if err := s.save(); err != nil {
+		s.keys[i].RevokedAt = nil
+		return nil, err
+	}
+	key := s.keys[i]
+	return &key, nil
+}
+
+// Match finds the key whose secret is presented and returns its principal.
+// Every stored hash is compared in constant time.
+func (s *KeyStore) Match(presented string, now time.Time) (*Principal, error) {
+	if presented == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	presentedHash := []byte(s.hash(presented))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var match *StoredKey
+	for i := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(s.keys[i].Hash), presentedHash) == 1 && s.keys[i].RevokedAt == nil {
+			match = &s.keys[i]
+		}
+	}
+
+	if match == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if match.ExpiresAt != nil && !now.Before(*match.ExpiresAt) {
+		return nil, ErrExpiredAPIKey
+	}
+
+	return &Principal{
+		Name:               match.Name,
+		Scopes:             match.Scopes,
+		AllowedSenders:     match.AllowedSenders,
+		AllowedIPs:         match.AllowedIPs,
+		RateLimitPerMinute: match.RateLimitPerMinute,
+		DailyQuota:         match.DailyQuota,
+		MonthlyQuota:       match.MonthlyQuota,
+	}, nil
+}
+
+// find returns the index of a key that is not revoked. The caller must
+// hold the lock.
+func (s *KeyStore) find(id string) (int, error) {
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			if s.keys[i].RevokedAt != nil {
+				return -1, ErrKeyRevoked
+			}
+			return i, nil
+		}
+	}
+	return -1, ErrKeyNotFound
+}
+
+// hash returns the hex HMAC-SHA256 of secret keyed by the pepper
+func (s *KeyStore) hash(secret string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// save writes the store to disk atomically. The caller must hold the lock.
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.keys, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create key store directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace key store: %w", err)
+	}
+	return nil
+}
+
+// LoadPepper reads the pepper kept in the file at path, generating a
+// random one readable only by the owner when the file does not exist
+func LoadPepper(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read pepper %s: %w", path, err)
+	}
+
+	buf := make([]byte, pepperSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate pepper: %w", err)
+	}
+	pepper := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create pepper directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create pepper %s: %w", path, err)
+	}
+	if _, err := f.WriteString(pepper + "\n"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write pepper %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write pepper %s: %w", path, err)
+	}
+	return pepper, nil
+}
+
+// generateSecret returns a new random API key secret
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return keySecretPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// secretHint returns the last characters of a secret so keys can be told
+// apart without revealing them
+func secretHint(secret string) string {
+	return "..." + secret[len(secret)-4:]
+}
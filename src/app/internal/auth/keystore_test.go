@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStoreLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	store, err := OpenKeyStore(path, "pepper")
+	assert.NoError(t, err)
+	assert.False(t, store.Active())
+
+	// Test the secret works and only its hash is persisted
+	secret, key, err := store.Create(StoredKey{Name: "billing", Scopes: []string{"send"}, AllowedIPs: []string{"10.0.0.0/8"}, DailyQuota: 50})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "smtogo_"))
+	principal, err := store.Match(secret, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", principal.Name)
+	assert.Equal(t, []string{"10.0.0.0/8"}, principal.AllowedIPs)
+	assert.Equal(t, 50, principal.DailyQuota)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+	assert.Contains(t, string(data), key.Hash)
+
+	// Test the store is reloaded from disk, and needs the same pepper
+	reopened, err := OpenKeyStore(path, "pepper")
+	assert.NoError(t, err)
+	_, err = reopened.Match(secret, time.Now())
+	assert.NoError(t, err)
+	otherPepper, err := OpenKeyStore(path, "other")
+	assert.NoError(t, err)
+	_, err = otherPepper.Match(secret, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// Test rotation invalidates the old secret
+	rotated, _, err := store.Rotate(key.ID)
+	assert.NoError(t, err)
+	_, err = store.Match(secret, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = store.Match(rotated, time.Now())
+	assert.NoError(t, err)
+
+	// Test revocation
+	_, err = store.Revoke(key.ID)
+	assert.NoError(t, err)
+	_, err = store.Match(rotated, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, _, err = store.Rotate(key.ID)
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+	_, err = store.Revoke("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.False(t, store.Active())
+	assert.Len(t, store.List(), 1)
+}
+
+func TestKeyStoreExpiry(t *testing.T) {
+	store, err := OpenKeyStore("", "")
+	assert.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	secret, _, err := store.Create(StoredKey{Name: "temp", Scopes: []string{"send"}, ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+
+	_, err = store.Match(secret, time.Now())
+	assert.NoError(t, err)
+	_, err = store.Match(secret, expiresAt)
+	assert.ErrorIs(t, err, ErrExpiredAPIKey)
+}
+
+func TestLoadPepper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_key_pepper")
+
+	// Test a pepper is generated once, readable only by the owner
+	pepper, err := LoadPepper(path)
+	assert.NoError(t, err)
+	assert.Len(t, pepper, 64)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	again, err := LoadPepper(path)
+	assert.NoError(t, err)
+	assert.Equal(t, pepper, again)
+
+	// Test a persistent store refuses to hash without a pepper
+	_, err = OpenKeyStore(filepath.Join(t.TempDir(), "api_keys.json"), "")
+	assert.Error(t, err)
+}
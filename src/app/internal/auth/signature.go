@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// Headers of a signed request
+const (
+	HeaderKeyID         = "X-Key-Id"
+	HeaderTimestamp     = "X-Timestamp"
+	HeaderNonce         = "X-Nonce"
+	HeaderContentSHA256 = "X-Content-Sha256"
+	HeaderSignature     = "X-Signature"
+)
+
+// Errors returned when a signed request is rejected
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrBodyDigest       = errors.New("body does not match " + HeaderContentSHA256)
+	ErrStaleTimestamp   = errors.New("request timestamp outside the allowed window")
+	ErrReplayedNonce    = errors.New("request nonce has already been used")
+)
+
+// SignedRequest holds the parts of an HTTP request covered by its signature
+type SignedRequest struct {
+	Method     string
+	RequestURI string
+	Body       []byte
+
+	KeyID         string
+	Timestamp     string
+	Nonce         string
+	ContentSHA256 string
+	Signature     string
+}
+
+// StringToSign returns the canonical string a client signs: method, request
+// URI, timestamp, nonce and hex SHA-256 of the body, joined by newlines
+func StringToSign(method, requestURI, timestamp, nonce, contentSHA256 string) string {
+	return strings.Join([]string{strings.ToUpper(method), requestURI, timestamp, nonce, contentSHA256}, "\n")
+}
+
+// Sign returns the hex HMAC-SHA256 of stringToSign keyed by the API key
+func Sign(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BodyDigest returns the hex SHA-256 of a request body
+func BodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySignature checks a signed request against the configured keys. The
+// key ID is the key name. Timestamps (Unix seconds) may differ from now by
+// at most maxSkew, and each nonce is accepted once within that window.
+func VerifySignature(keys []config.APIKey, req *SignedRequest, now time.Time, maxSkew time.Duration, nonces *NonceCache) (*Principal, error) {
+	var key *config.APIKey
+	for i := range keys {
+		if keys[i].Name == req.KeyID {
+			key = &keys[i]
+		}
+	}
+	if key == nil || req.Signature == "" || req.Nonce == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	// Check the signature before anything else so that unauthenticated
+	// callers learn nothing and cannot fill the nonce cache
+	expected := Sign(key.Key, StringToSign(req.Method, req.RequestURI, req.Timestamp, req.Nonce, req.ContentSHA256))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(req.Signature))) {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(BodyDigest(req.Body)), []byte(strings.ToLower(req.ContentSHA256))) {
+		return nil, ErrBodyDigest
+	}
+
+	seconds, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrStaleTimestamp
+	}
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return nil, ErrStaleTimestamp
+	}
+
+	// A nonce must be remembered for as long as its timestamp is accepted
+	if !nonces.Use(key.Name+"\n"+req.Nonce, signedAt.Add(maxSkew), now) {
+		return nil, ErrReplayedNonce
+	}
+
+	if key.Expired(now) {
+		return nil, ErrExpiredAPIKey
+	}
+	return principalFromKey(key), nil
+}
+
+// nonceSweepInterval is how often expired nonces are dropped
+const nonceSweepInterval = time.Minute
+
+// NonceCache remembers nonces until their signatures expire
+type NonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+
+	// nextSweep is when expired nonces are next dropped
+	nextSweep time.Time
+}
+
+// NewNonceCache creates an empty nonce cache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{nonces: make(map[string]time.Time)}
+}
+
+// Use records a nonce valid until expires. It returns false if the nonce
+// was already recorded and has not expired.
+func (n *NonceCache) Use(nonce string, expires, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if until, ok := n.nonces[nonce]; ok && now.Before(until) {
+		return false
+	}
+
+	// Drop expired nonces once per interval so the map does not grow
+	// forever, without scanning it on every request
+	if !now.Before(n.nextSweep) {
+		for k, until := range n.nonces {
+			if !now.Before(until) {
+				delete(n.nonces, k)
+			}
+		}
+		n.nextSweep = now.Add(nonceSweepInterval)
+	}
+
+	n.nonces[nonce] = expires
+	return true
+}
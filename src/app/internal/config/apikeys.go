@@ -0,0 +1,110 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scopes grant API keys access to groups of endpoints
+const (
+	ScopeSend       = "send"
+	ScopeReadStatus = "read-status"
+	ScopeAdmin      = "admin"
+)
+
+// Scopes lists every known scope
+var Scopes = []string{ScopeSend, ScopeReadStatus, ScopeAdmin}
+
+// LegacyAPIKeyName names the key given by the top-level api_key field
+const LegacyAPIKeyName = "default"
+
+// LegacyAPIKeyScopes are the scopes of the top-level api_key unless
+// api_key_scopes lists others
+var LegacyAPIKeyScopes = []string{ScopeSend, ScopeReadStatus}
+
+// APIKey is a named API key with its permissions
+type APIKey struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key" secret:"true"`
+	Scopes []string `json:"scopes"`
+
+	// Sender identities the key may use (empty means all)
+	AllowedSenders []string `json:"allowed_senders"`
+
+	// Client IP ranges the key may be used from (empty means all)
+	AllowedIPs []string `json:"allowed_ips"`
+
+	// ExpiresAt is the RFC 3339 time after which the key is rejected
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// RequireSignature rejects the key when sent as a static X-API-Key
+	// header, so that it can only be used to sign requests
+	RequireSignature bool `json:"require_signature"`
+
+	// Overrides of the rate_limit defaults (zero keeps the default)
+	RateLimitPerMinute int `json:"rate_limit_per_minute"`
+	DailyQuota         int `json:"daily_quota"`
+	MonthlyQuota       int `json:"monthly_quota"`
+}
+
+// Expired reports whether the key has expired at the given time
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// AllAPIKeys returns every configured key, including the one given by the
+// top-level api_key field with its api_key_scopes
+func (c *Config) AllAPIKeys() []APIKey {
+	var keys []APIKey
+	if strings.TrimSpace(c.APIKey) != "" {
+		scopes := c.APIKeyScopes
+		if len(scopes) == 0 {
+			scopes = LegacyAPIKeyScopes
+		}
+		keys = append(keys, APIKey{
+			Name:           LegacyAPIKeyName,
+			Key:            c.APIKey,
+			Scopes:         scopes,
+			AllowedSenders: c.APIKeySenders,
+			AllowedIPs:     c.APIKeyAllowedIPs,
+		})
+	}
+	return append(keys, c.APIKeys...)
+}
+
+// QuotaUsagePath returns the file holding per-key send counts
+func (c *Config) QuotaUsagePath() string {
+	return filepath.Join(c.DataDir, "quota_usage.json")
+}
+
+// KeyStorePath returns the file holding managed API keys, which defaults
+// to api_keys.json in the data directory
+func (c *Config) KeyStorePath() string {
+	if c.APIKeyStore != "" {
+		return c.APIKeyStore
+	}
+	return filepath.Join(c.DataDir, "api_keys.json")
+}
+
+// ReservedKeyName reports whether name starts with a prefix reserved for
+// principals that are not API keys
+func ReservedKeyName(name string) bool {
+	return strings.HasPrefix(name, JWTPrincipalPrefix) || strings.HasPrefix(name, CertPrincipalPrefix)
+}
+
+// KeyStorePepperPath returns the file holding the pepper generated for
+// the key store when api_key_pepper is not set
+func (c *Config) KeyStorePepperPath() string {
+	return filepath.Join(c.DataDir, "api_key_pepper")
+}
+
+// ValidScope reports whether scope is a known scope
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Archive configures rolling finished days of email results into
+// compressed bundles
+type Archive struct {
+	Enabled bool `json:"enabled"`
+
+	// Dir holds the bundles, by default archive in the data directory
+	Dir string `json:"dir"`
+
+	// AfterDays is how many days old results must be to be archived; 1
+	// archives every day before today
+	AfterDays int `json:"after_days"`
+
+	// KeepDays is how long bundles are kept; 0 keeps them forever. Keep
+	// at least 365 where a year of send logs must be retained.
+	KeepDays int `json:"keep_days"`
+}
+
+// ArchiveDir returns the directory of archive bundles
+func (c *Config) ArchiveDir() string {
+	if c.Archive.Dir != "" {
+		return c.Archive.Dir
+	}
+	return filepath.Join(c.DataDir, "archive")
+}
+
+// setDefaults archives every finished day
+func (a *Archive) setDefaults() {
+	if a.AfterDays == 0 {
+		a.AfterDays = 1
+	}
+}
+
+// validate checks the archive periods, and that retention does not remove
+// results before they are archived
+func (a *Archive) validate(retention Retention) []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if a.AfterDays < 0 {
+		addf("archive.after_days must not be negative, got %d", a.AfterDays)
+	}
+	if a.KeepDays < 0 {
+		addf("archive.keep_days must not be negative, got %d", a.KeepDays)
+	}
+	if a.Enabled {
+		for _, p := range []struct {
+			name string
+			days int
+		}{
+			{"success_days", retention.SuccessDays},
+			{"failure_days", retention.FailureDays},
+		} {
+			if p.days > 0 && p.days < a.AfterDays {
+				addf("retention.%s (%d) must not be shorter than archive.after_days (%d), or results are removed before they are archived", p.name, p.days, a.AfterDays)
+			}
+		}
+	}
+	return problems
+}
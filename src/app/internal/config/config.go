@@ -2,18 +2,35 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
+// EnvPrefix is prepended to the upper-cased JSON name of every config field
+// to form the environment variable that overrides it, e.g. SMTOGO_SMTP_PORT.
+const EnvPrefix = "SMTOGO_"
+
+// DefaultPath is the config file used when no path is given
+const DefaultPath = "config/smtp_config.jsonc"
+
 // Config represents the application configuration
 type Config struct {
 	// API Configuration
-	APIKey         string `json:"api_key"`
+	APIKey         string `json:"api_key" secret:"true"`
 	APIName        string `json:"api_name"`
 	APIDescription string `json:"api_description"`
 	Port           int    `json:"port"`
+	BindAddress    string `json:"bind_address"`
+	UnixSocket     string `json:"unix_socket"`
+	Listen         string `json:"listen"`
+
+	// Storage Settings
+	DataDir string `json:"data_dir"`
 
 	// SMTP Server Settings
 	SMTPServer  string `json:"smtp_server"`
@@ -31,18 +48,115 @@ type Config struct {
 	SenderEmail        string `json:"sender_email"`
 	SenderEmailDisplay string `json:"sender_email_display"`
 	SenderDomain       string `json:"sender_domain"`
-	SenderPassword     string `json:"sender_password"`
+	SenderPassword     string `json:"sender_password" secret:"true"`
+
+	// Additional sender identities selectable per request
+	Senders       []SenderIdentity `json:"senders"`
+	DefaultSender string           `json:"default_sender"`
+
+	// Scopes of the API key (default: send and read-status). The admin
+	// scope must be listed explicitly.
+	APIKeyScopes []string `json:"api_key_scopes"`
+
+	// Sender identities the API key may use (empty means all)
+	APIKeySenders []string `json:"api_key_senders"`
+
+	// Client IP ranges the API key may be used from (empty means all)
+	APIKeyAllowedIPs []string `json:"api_key_allowed_ips"`
+
+	// Named API keys with scopes, in addition to api_key
+	APIKeys []APIKey `json:"api_keys"`
+
+	// Store for keys managed through the admin API, and the pepper their
+	// hashes are keyed with
+	APIKeyStore  string `json:"api_key_store"`
+	APIKeyPepper string `json:"api_key_pepper" secret:"true"`
+
+	// Inbound request limits and send quotas
+	RateLimit RateLimit `json:"rate_limit"`
+
+	// Reverse proxies whose forwarding headers are trusted, as IPs or CIDRs
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// Client IP ranges allowed per route
+	AllowedIPs AllowedIPs `json:"allowed_ips"`
+
+	// Maximum age in seconds of a signed request's timestamp
+	SignatureMaxSkew int `json:"signature_max_skew"`
+
+	// Bearer token authentication
+	JWT JWTAuth `json:"jwt"`
+
+	// HTTPS and client certificate authentication
+	TLS TLSConfig `json:"tls"`
+
+	// Log level and format
+	Log Logging `json:"log"`
+
+	// OpenTelemetry trace export
+	Tracing Tracing `json:"tracing"`
+
+	// Retries of failed deliveries
+	Delivery Delivery `json:"delivery"`
+
+	// Readiness checks
+	Health Health `json:"health"`
+
+	// Backend for email results
+	Storage Storage `json:"storage"`
+
+	// How long results and debug dumps are kept
+	Retention Retention `json:"retention"`
+
+	// Compressed bundles of old results
+	Archive Archive `json:"archive"`
+
+	// Keys that encrypt records in the data directory
+	Encryption Encryption `json:"encryption"`
+
+	// Personal data kept in results and logs
+	Redaction Redaction `json:"redaction"`
+}
+
+// RateLimit holds the default request limits and send quotas. Zero means
+// unlimited.
+type RateLimit struct {
+	PerIPPerMinute  int `json:"per_ip_per_minute"`
+	PerKeyPerMinute int `json:"per_key_per_minute"`
+	DailyQuota      int `json:"daily_quota"`
+	MonthlyQuota    int `json:"monthly_quota"`
 }
 
-// Load reads configuration from file
-func Load() (*Config, error) {
-	configPath := "config/smtp_config.jsonc"
+// Load reads configuration from file and applies environment overrides.
+// An empty path means DefaultPath, which is optional; when it is missing the
+// configuration is built from environment variables alone. An explicitly
+// given path must exist.
+func Load(configPath string) (*Config, error) {
+	explicit := configPath != ""
+	if !explicit {
+		configPath = DefaultPath
+	}
 
+	config := &Config{}
 	if _, err := os.Stat(configPath); err == nil {
-		return loadFromFile(configPath)
+		if config, err = loadFromFile(configPath); err != nil {
+			return nil, err
+		}
+	} else if explicit || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to access config file %s: %w", configPath, err)
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(reflect.ValueOf(config), "", os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
 	}
 
-	return nil, fmt.Errorf("config file not found at: %v", configPath)
+	// Set defaults again in case the file was absent
+	config.setDefaults()
+
+	return config, nil
 }
 
 // loadFromFile loads configuration from a specific file
@@ -52,13 +166,8 @@ func loadFromFile(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
-	// Remove comments for JSONC files
-	if strings.HasSuffix(path, ".jsonc") {
-		data = removeJSONComments(data)
-	}
-
 	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
+	if err := decodeFile(path, data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
 
@@ -76,6 +185,25 @@ func (c *Config) setDefaults() {
 	if c.APIDescription == "" {
 		c.APIDescription = "SMTP API mail dispatch with support for attachments."
 	}
+	if c.Port == 0 {
+		c.Port = 8000
+	}
+	if c.DataDir == "" {
+		c.DataDir = "data"
+	}
+	if c.SignatureMaxSkew == 0 {
+		c.SignatureMaxSkew = 300
+	}
+	c.JWT.setDefaults()
+	c.Log.setDefaults()
+	c.Tracing.setDefaults()
+	c.Delivery.setDefaults()
+	c.Health.setDefaults()
+	c.Storage.setDefaults()
+	c.Retention.setDefaults()
+	c.Archive.setDefaults()
+	c.Encryption.setDefaults()
+	c.Redaction.setDefaults()
 	if c.MaxLenRecipientEmail == 0 {
 		c.MaxLenRecipientEmail = 64
 	}
@@ -87,9 +215,93 @@ func (c *Config) setDefaults() {
 	}
 }
 
+// applyEnv overrides config fields from environment variables named
+// EnvPrefix + upper-cased JSON field name. Following the Docker secrets
+// convention, the same name with a _FILE suffix gives a file to read the
+// value from. Every malformed value is reported.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := EnvPrefix + strings.ToUpper(name)
+		raw, ok := lookup(key)
+		if !ok {
+			path, ok := lookup(key + "_FILE")
+			if !ok {
+				continue
+			}
+			secret, err := readSecretFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value for %s_FILE: %w", key, err))
+				continue
+			}
+			raw = secret
+		}
+
+		if err := setFieldFromString(v.Field(i), raw); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for %s: %w", key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// setFieldFromString converts raw to the kind of field and stores it
+func setFieldFromString(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", raw)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", raw)
+		}
+		field.SetBool(b)
+	default:
+		// Structured values (lists, objects) are given as JSON
+		if err := json.Unmarshal([]byte(raw), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("expected JSON for %s: %w", field.Type(), err)
+		}
+	}
+
+	return nil
+}
+
+// ListenAddress returns the network ("tcp" or "unix") and address the HTTP
+// server binds to. An explicit listen value wins; it may name a Unix socket
+// as "unix:/path". Otherwise unix_socket is used if set, then
+// bind_address and port.
+func (c *Config) ListenAddress() (network, address string) {
+	if path, ok := strings.CutPrefix(c.Listen, "unix:"); ok {
+		return "unix", path
+	}
+	if c.Listen != "" {
+		return "tcp", c.Listen
+	}
+	if c.UnixSocket != "" {
+		return "unix", c.UnixSocket
+	}
+	return "tcp", net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+}
+
 // IsAPIKeyAuthEnabled returns true if API key authentication is enabled
 func (c *Config) IsAPIKeyAuthEnabled() bool {
-	return strings.TrimSpace(c.APIKey) != ""
+	return strings.TrimSpace(c.APIKey) != "" || len(c.APIKeys) > 0
 }
 
 // GetDisplayEmail returns the display email or falls back to sender email
@@ -99,25 +311,3 @@ func (c *Config) GetDisplayEmail() string {
 	}
 	return c.SenderEmail
 }
-
-// removeJSONComments removes single-line comments from JSONC
-func removeJSONComments(data []byte) []byte {
-	lines := strings.Split(string(data), "\n")
-	var cleanLines []string
-
-	for _, line := range lines {
-		// Find comment position
-		commentPos := strings.Index(line, "//")
-		if commentPos != -1 {
-			// Keep only the part before the comment
-			line = strings.TrimSpace(line[:commentPos])
-			// Remove trailing comma if it exists after removing comment
-			if strings.HasSuffix(line, ",") && (commentPos > 0) {
-				// This is a simple approach; a more robust parser would handle edge cases
-			}
-		}
-		cleanLines = append(cleanLines, line)
-	}
-
-	return []byte(strings.Join(cleanLines, "\n"))
-}
@@ -1,8 +1,15 @@
 package config
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,9 +20,7 @@ func TestLoadConfig(t *testing.T) {
 		"api_name": "Test API",
 		"smtp_server": "smtp.test.com",
 		"smtp_port": 587,
-		"sender_email": "test@example.com",
-		"minio_endpoint": "localhost:9000",
-		"minio_bucket": "test-bucket"
+		"sender_email": "test@example.com"
 	}`
 
 	tmpFile, err := os.CreateTemp("", "test_config*.json")
@@ -36,21 +41,51 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, "test@example.com", config.SenderEmail)
 }
 
-func TestRemoveJSONComments(t *testing.T) {
+func TestStripJSONC(t *testing.T) {
 	input := `{
 		"field1": "value1", // This is a comment
-		"field2": "value2"
+		/* block
+		   comment */
+		"webhook": "https://example.com/hook", // URL must survive
+		"escaped": "quote \" // not a comment",
+		"list": [1, 2,],
 	}`
 
-	_ = `{
-		"field1": "value1", 
-		"field2": "value2"
-	}`
+	result, err := stripJSONC([]byte(input))
+	assert.NoError(t, err)
+	assert.Len(t, result, len(input))
+	assert.NotContains(t, string(result), "This is a comment")
+	assert.NotContains(t, string(result), "block")
+	assert.Contains(t, string(result), `"https://example.com/hook"`)
+	assert.Contains(t, string(result), `"quote \" // not a comment"`)
+
+	var out map[string]interface{}
+	assert.NoError(t, json.Unmarshal(result, &out))
+	assert.Equal(t, "https://example.com/hook", out["webhook"])
+	assert.Len(t, out["list"], 2)
+}
+
+func TestDecodeJSONCErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unknown field", "{\n  \"smtp_sever\": \"x\"\n}", `line 2, column 3: unknown field "smtp_sever"`},
+		{"syntax error", "{\n  \"port\" 1\n}", "line 2, column 10: invalid character '1'"},
+		{"type error", "{\n  \"port\": \"x\"\n}", "line 2, column"},
+		{"unterminated comment", "{\n  /* open\n}", "line 2, column 3: unterminated block comment"},
+		{"trailing data", "{} x", "unexpected data"},
+	}
 
-	result := removeJSONComments([]byte(input))
-	assert.Contains(t, string(result), "field1")
-	assert.Contains(t, string(result), "value1")
-	assert.NotContains(t, string(result), "// This is a comment")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := decodeJSONC([]byte(tt.input), &config)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
 }
 
 func TestIsAPIKeyAuthEnabled(t *testing.T) {
@@ -94,4 +129,680 @@ func TestSetDefaults(t *testing.T) {
 	assert.Equal(t, 64, config.MaxLenRecipientEmail)
 	assert.Equal(t, 255, config.MaxLenSubject)
 	assert.Equal(t, 50000, config.MaxLenBody)
+	assert.Equal(t, "data", config.DataDir)
+	assert.Equal(t, 8000, config.Port)
+}
+
+func TestListenAddress(t *testing.T) {
+	config := &Config{Port: 8025}
+
+	// Test port on all interfaces
+	network, addr := config.ListenAddress()
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, ":8025", addr)
+
+	// Test bind address
+	config.BindAddress = "127.0.0.1"
+	_, addr = config.ListenAddress()
+	assert.Equal(t, "127.0.0.1:8025", addr)
+
+	// Test Unix socket
+	config.UnixSocket = "/run/smtogo.sock"
+	network, addr = config.ListenAddress()
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/run/smtogo.sock", addr)
+
+	// Test explicit listen value wins
+	config.Listen = "[::1]:9000"
+	network, addr = config.ListenAddress()
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "[::1]:9000", addr)
+
+	config.Listen = "unix:/tmp/other.sock"
+	network, addr = config.ListenAddress()
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/tmp/other.sock", addr)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"SMTOGO_SMTP_SERVER": "smtp.env.com",
+		"SMTOGO_SMTP_PORT":   " 2525 ",
+		"SMTOGO_USE_TLS":     "true",
+		"SMTOGO_API_KEY":     "env-key",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	config := &Config{SMTPServer: "smtp.file.com", SenderEmail: "file@example.com"}
+	assert.NoError(t, config.applyEnv(lookup))
+	assert.Equal(t, "smtp.env.com", config.SMTPServer)
+	assert.Equal(t, 2525, config.SMTPPort)
+	assert.True(t, config.UseTLS)
+	assert.Equal(t, "env-key", config.APIKey)
+	assert.Equal(t, "file@example.com", config.SenderEmail)
+}
+
+func TestApplyEnvMalformed(t *testing.T) {
+	env := map[string]string{
+		"SMTOGO_SMTP_PORT": "abc",
+		"SMTOGO_USE_SSL":   "maybe",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	err := (&Config{}).applyEnv(lookup)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTOGO_SMTP_PORT")
+	assert.Contains(t, err.Error(), "SMTOGO_USE_SSL")
+}
+
+func TestLoadFromEnvOnly(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	t.Setenv("SMTOGO_SMTP_SERVER", "smtp.env.com")
+	t.Setenv("SMTOGO_SMTP_PORT", "587")
+
+	config, err := Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp.env.com", config.SMTPServer)
+	assert.Equal(t, 587, config.SMTPPort)
+	assert.Equal(t, "High-Performance SMTP API", config.APIName)
+}
+
+func TestLoadExplicitPathMissing(t *testing.T) {
+	_, err := Load("/nonexistent/smtp_config.jsonc")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		UseTLS:      true,
+		SenderEmail: "sender@example.com",
+	}
+	config.setDefaults()
+	assert.NoError(t, config.Validate())
+
+	// Test every problem is reported at once
+	config.SMTPServer = ""
+	config.SMTPPort = 0
+	config.UseSSL = true
+	config.UsePassword = true
+	config.SenderEmail = "Sender <sender@example.com>"
+	config.Listen = "8000"
+
+	err := config.Validate()
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Problems, 6)
+	assert.Contains(t, err.Error(), "smtp_server is required")
+	assert.Contains(t, err.Error(), "smtp_port must be between 1 and 65535")
+	assert.Contains(t, err.Error(), "use_ssl and use_tls are mutually exclusive")
+	assert.Contains(t, err.Error(), "sender_password is required")
+	assert.Contains(t, err.Error(), "sender_email must be a bare address")
+	assert.Contains(t, err.Error(), "listen must be host:port")
+}
+
+func TestSampleConfigIsValid(t *testing.T) {
+	config, err := Load("../../../../config/smtp_config.jsonc")
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp_config.jsonc")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"port": 8000}`), 0644))
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, path, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	// Test an unchanged file is not reported
+	select {
+	case <-changed:
+		t.Fatal("unexpected change notification")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test a rewritten file is reported
+	assert.NoError(t, os.WriteFile(path, []byte(`{"port": 8001}`), 0644))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change was not reported")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "smtp_pass")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+
+	env := map[string]string{"SMTP_API_KEY": "env-secret"}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	config := &Config{
+		SenderPassword: "file:" + secretFile,
+		APIKey:         "env:SMTP_API_KEY",
+		SMTPServer:     "env:NOT_A_SECRET_FIELD",
+	}
+	assert.NoError(t, resolveSecrets(reflect.ValueOf(config), "", lookup))
+	assert.Equal(t, "file-secret", config.SenderPassword)
+	assert.Equal(t, "env-secret", config.APIKey)
+	assert.Equal(t, "env:NOT_A_SECRET_FIELD", config.SMTPServer)
+
+	// Test unresolvable references name the field
+	config = &Config{SenderPassword: "file:/nonexistent", APIKey: "env:UNSET"}
+	err := resolveSecrets(reflect.ValueOf(config), "", lookup)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sender_password")
+	assert.Contains(t, err.Error(), "api_key: environment variable UNSET is not set")
+}
+
+func TestApplyEnvFileSuffix(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "smtp_pass")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0600))
+
+	env := map[string]string{"SMTOGO_SENDER_PASSWORD_FILE": secretFile}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	config := &Config{}
+	assert.NoError(t, config.applyEnv(lookup))
+	assert.Equal(t, "from-file", config.SenderPassword)
+
+	// Test the plain variable wins over _FILE
+	env["SMTOGO_SENDER_PASSWORD"] = "direct"
+	assert.NoError(t, config.applyEnv(lookup))
+	assert.Equal(t, "direct", config.SenderPassword)
+}
+
+func TestLoadFormats(t *testing.T) {
+	files := map[string]string{
+		"config.jsonc": `{
+			"smtp_server": "smtp.example.com", // comment
+			"smtp_port": 587,
+			"use_tls": true,
+		}`,
+		"config.yaml": `
+smtp_server: smtp.example.com
+smtp_port: 587
+use_tls: true
+`,
+		"config.toml": `
+smtp_server = "smtp.example.com"
+smtp_port = 587
+use_tls = true
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+		config, err := loadFromFile(path)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "smtp.example.com", config.SMTPServer, name)
+		assert.Equal(t, 587, config.SMTPPort, name)
+		assert.True(t, config.UseTLS, name)
+		assert.Equal(t, 255, config.MaxLenSubject, name)
+	}
+
+	// Test unknown keys are rejected in every format
+	path := filepath.Join(dir, "typo.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("smtp_sever: x\n"), 0644))
+	_, err := loadFromFile(path)
+	assert.ErrorContains(t, err, `unknown field "smtp_sever"`)
+}
+
+func TestMasked(t *testing.T) {
+	config := &Config{APIKey: "key", SenderPassword: "", SMTPServer: "smtp.example.com"}
+
+	masked := config.Masked()
+	assert.Equal(t, "********", masked.APIKey)
+	assert.Equal(t, "", masked.SenderPassword)
+	assert.Equal(t, "smtp.example.com", masked.SMTPServer)
+	assert.Equal(t, "key", config.APIKey)
+}
+
+func TestFindSender(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		Senders: []SenderIdentity{
+			{ID: "billing", Email: "billing@example.com"},
+			{ID: "alerts", Email: "alerts@example.net", Relay: &Relay{SMTPServer: "relay.example.net", SMTPPort: 25}},
+		},
+	}
+
+	// Test the top-level sender is the default identity
+	identity, ok := config.FindSender("")
+	assert.True(t, ok)
+	assert.Equal(t, "default", identity.ID)
+	assert.Equal(t, "noreply@example.com", identity.Email)
+
+	// Test identities inherit the global relay and derive their domain
+	identity, ok = config.FindSender("billing")
+	assert.True(t, ok)
+	assert.Equal(t, "smtp.example.com", identity.Relay.SMTPServer)
+	assert.Equal(t, "example.com", identity.Domain)
+
+	// Test identities can use their own relay
+	identity, ok = config.FindSenderByEmail("ALERTS@example.net")
+	assert.True(t, ok)
+	assert.Equal(t, "alerts", identity.ID)
+	assert.Equal(t, "relay.example.net", identity.Relay.SMTPServer)
+
+	// Test the default can be switched
+	config.DefaultSender = "billing"
+	identity, _ = config.FindSender("")
+	assert.Equal(t, "billing", identity.ID)
+
+	_, ok = config.FindSender("unknown")
+	assert.False(t, ok)
+}
+
+func TestValidateSenders(t *testing.T) {
+	config := &Config{
+		SMTPServer: "smtp.example.com",
+		SMTPPort:   587,
+		Senders: []SenderIdentity{
+			{ID: "billing", Email: "billing@example.com", UsePassword: true},
+			{ID: "billing", Email: "not-an-address"},
+			{ID: "alerts", Email: "alerts@example.com", Relay: &Relay{SMTPServer: "relay", UseSSL: true, UseTLS: true}},
+		},
+		APIKeySenders: []string{"marketing"},
+	}
+	config.setDefaults()
+
+	err := config.Validate()
+	assert.Error(t, err)
+	for _, want := range []string{
+		`senders["billing"].password is required`,
+		`senders[1].id "billing" is already used`,
+		`senders[1].email must be a bare address`,
+		`senders["alerts"].relay.smtp_port must be between 1 and 65535`,
+		`senders["alerts"].relay: use_ssl and use_tls are mutually exclusive`,
+		"default_sender is required",
+		`api_key_senders: "marketing" does not name a sender identity`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+	assert.NotContains(t, err.Error(), "sender_email is required")
+}
+
+func TestValidateAPIKeys(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		APIKey:      "legacy",
+		APIKeys: []APIKey{
+			{Name: "billing", Key: "k1", Scopes: []string{ScopeSend}},
+			{Name: "billing", Key: "legacy", Scopes: []string{"delete"}},
+			{Name: "ops", Key: "k1", AllowedSenders: []string{"alerts"}},
+			{Name: "jwt:billing", Key: "k2", Scopes: []string{ScopeSend}},
+		},
+	}
+	config.setDefaults()
+
+	err := config.Validate()
+	assert.Error(t, err)
+	for _, want := range []string{
+		`api_keys[1].name "billing" is already used`,
+		`api_keys[1].key must be unique`,
+		`api_keys[1].scopes: unknown scope "delete"`,
+		`api_keys["ops"].key must be unique`,
+		`api_keys["ops"].scopes must list at least one`,
+		`api_keys["ops"].allowed_senders: "alerts" does not name a sender identity`,
+		`api_keys[3].name "jwt:billing" uses a prefix reserved for other principals`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	// Test the legacy key can send and read status, but is not an admin
+	// unless granted explicitly
+	keys := config.AllAPIKeys()
+	assert.Len(t, keys, 5)
+	assert.Equal(t, "default", keys[0].Name)
+	assert.Equal(t, []string{ScopeSend, ScopeReadStatus}, keys[0].Scopes)
+
+	config.APIKeyScopes = []string{ScopeAdmin, "delete"}
+	assert.Equal(t, []string{ScopeAdmin, "delete"}, config.AllAPIKeys()[0].Scopes)
+	assert.Contains(t, config.Validate().Error(), `api_key_scopes: unknown scope "delete"`)
+}
+
+func TestValidateJWT(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		JWT: JWTAuth{
+			JWKSFile:     "jwks.json",
+			JWKSURL:      "ftp://idp.example.com/jwks",
+			ScopeMapping: map[string][]string{"mail:send": {"send", "delete"}},
+		},
+	}
+	config.setDefaults()
+	assert.True(t, config.IsJWTAuthEnabled())
+	assert.Equal(t, "sub", config.JWT.NameClaim)
+
+	err := config.Validate()
+	assert.Error(t, err)
+	for _, want := range []string{
+		"jwt.jwks_file and jwt.jwks_url are mutually exclusive",
+		`jwt.jwks_url must be an http or https URL`,
+		"jwt.issuer is required",
+		"jwt.audience is required",
+		`jwt.scope_mapping["mail:send"]: unknown scope "delete"`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		TLS: TLSConfig{
+			CertFile:          "server.crt",
+			RequireClientCert: true,
+			ClientCerts: []ClientCert{
+				{Subject: "billing", Scopes: []string{ScopeSend}, AllowedSenders: []string{"alerts"}, AllowedIPs: []string{"10.0.0.0/33"}},
+				{Subject: "billing", DailyQuota: -1},
+			},
+		},
+	}
+	config.setDefaults()
+	assert.True(t, config.IsTLSEnabled())
+	assert.True(t, config.IsClientCertAuthEnabled())
+
+	err := config.Validate()
+	assert.Error(t, err)
+	for _, want := range []string{
+		"tls.cert_file and tls.key_file must be set together",
+		"tls.require_client_cert requires tls.client_ca_file",
+		"tls.client_certs requires tls.client_ca_file",
+		`tls.client_certs["billing"].allowed_senders: "alerts" does not name a sender identity`,
+		`tls.client_certs[1].subject "billing" is already used`,
+		`tls.client_certs[1].scopes must list at least one`,
+		`tls.client_certs["billing"].allowed_ips`,
+		`tls.client_certs[1]: rate_limit_per_minute, daily_quota and monthly_quota must not be negative`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	ranges := []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"}
+
+	assert.True(t, IPAllowed(nil, "203.0.113.9"))
+	assert.True(t, IPAllowed(ranges, "10.1.2.3"))
+	assert.True(t, IPAllowed(ranges, "192.168.1.5"))
+	assert.True(t, IPAllowed(ranges, "fd00::1"))
+	assert.False(t, IPAllowed(ranges, "192.168.1.6"))
+	assert.False(t, IPAllowed(ranges, ""))
+
+	config := &Config{
+		SMTPServer:     "smtp.example.com",
+		SMTPPort:       587,
+		SenderEmail:    "noreply@example.com",
+		TrustedProxies: []string{"10.0.0.0/33"},
+		AllowedIPs:     AllowedIPs{Send: []string{"private"}},
+		APIKeys: []APIKey{
+			{Name: "ops", Key: "k1", Scopes: []string{ScopeSend}, AllowedIPs: []string{"10.0.0.1/"}},
+		},
+	}
+	config.setDefaults()
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `trusted_proxies: invalid CIDR "10.0.0.0/33"`)
+	assert.Contains(t, err.Error(), `allowed_ips.send: invalid IP address "private"`)
+	assert.Contains(t, err.Error(), `api_keys["ops"].allowed_ips: invalid CIDR "10.0.0.1/"`)
+}
+
+func TestValidateLog(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.Equal(t, "info", config.Log.Level)
+	assert.Equal(t, "text", config.Log.Format)
+	assert.NoError(t, config.Validate())
+
+	config.Log = Logging{Level: "verbose", Format: "xml"}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `log.level must be debug, info, warn or error, got "verbose"`)
+	assert.Contains(t, err.Error(), `log.format must be text or json, got "xml"`)
+	assert.Equal(t, slog.LevelInfo, config.Log.SlogLevel())
+}
+
+func TestValidateTracing(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.False(t, config.IsTracingEnabled())
+	assert.Equal(t, "smtogo", config.Tracing.ServiceName)
+	assert.Equal(t, 1.0, config.Tracing.Ratio())
+	assert.NoError(t, config.Validate())
+
+	config.Tracing.Endpoint = "http://localhost:4318/v1/traces"
+	assert.True(t, config.IsTracingEnabled())
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, "http://localhost:4318/v1/traces", config.Tracing.TracesURL())
+
+	// Test a collector URL without a path gets the traces path
+	config.Tracing.Endpoint = "http://localhost:4318"
+	assert.Equal(t, "http://localhost:4318/v1/traces", config.Tracing.TracesURL())
+	config.Tracing.Endpoint = "https://otel.example.com/custom"
+	assert.Equal(t, "https://otel.example.com/custom", config.Tracing.TracesURL())
+
+	// Test sampling can be turned off
+	config.Tracing.SampleRatio = nil
+	assert.NoError(t, json.Unmarshal([]byte(`{"sample_ratio": 0}`), &config.Tracing))
+	config.setDefaults()
+	assert.Equal(t, 0.0, config.Tracing.Ratio())
+
+	ratio := 1.5
+	config.Tracing = Tracing{Endpoint: "localhost:4318", SampleRatio: &ratio}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `tracing.endpoint must be an http or https URL, got "localhost:4318"`)
+	assert.Contains(t, err.Error(), "tracing.sample_ratio must be between 0 and 1, got 1.5")
+}
+
+func TestValidateHealth(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.Equal(t, SMTPCheckConnect, config.Health.SMTPCheck)
+	assert.Equal(t, 30, config.Health.SMTPCheckInterval)
+	assert.Equal(t, 5*time.Second, config.Health.CheckTimeout())
+	assert.NoError(t, config.Validate())
+
+	config.Health = Health{SMTPCheck: "ping", Timeout: -1, MaxQueueDepth: -5}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `health.smtp_check must be connect, auth or off, got "ping"`)
+	assert.Contains(t, err.Error(), "health.timeout must not be negative, got -1")
+	assert.Contains(t, err.Error(), "health.max_queue_depth must not be negative, got -5")
+	assert.Equal(t, 5*time.Second, config.Health.CheckTimeout())
+}
+
+func TestValidateDelivery(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.Equal(t, 0, config.Delivery.MaxRetries)
+	assert.Equal(t, 5*time.Second, config.Delivery.FirstRetryDelay())
+	assert.NoError(t, config.Validate())
+
+	config.Delivery = Delivery{MaxRetries: 11, RetryDelay: -1}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "delivery.max_retries must be between 0 and 10, got 11")
+	assert.Contains(t, err.Error(), "delivery.retry_delay must not be negative, got -1")
+}
+
+func TestValidateStorage(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		DataDir:     "data",
+	}
+	config.setDefaults()
+	assert.Equal(t, StorageFilesystem, config.Storage.Backend)
+	assert.Equal(t, filepath.Join("data", "emails.db"), config.ResultDBPath())
+	assert.NoError(t, config.Validate())
+
+	config.Storage = Storage{Backend: "sqlite", SQLitePath: "/var/lib/smtogo/emails.db"}
+	assert.Equal(t, "/var/lib/smtogo/emails.db", config.ResultDBPath())
+	assert.NoError(t, config.Validate())
+
+	config.Storage.Backend = "postgres"
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `storage.backend must be filesystem or sqlite, got "postgres"`)
+}
+
+func TestValidateRetention(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.False(t, config.IsRetentionEnabled())
+	assert.Equal(t, time.Hour, config.Retention.JanitorInterval())
+	assert.NoError(t, config.Validate())
+
+	config.Retention = Retention{SuccessDays: 30, DebugDays: 7, Interval: 15}
+	assert.True(t, config.IsRetentionEnabled())
+	assert.Equal(t, 15*time.Minute, config.Retention.JanitorInterval())
+	assert.NoError(t, config.Validate())
+
+	config.Retention = Retention{FailureDays: -1, Interval: -5}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "retention.failure_days must not be negative, got -1")
+	assert.Contains(t, err.Error(), "retention.interval must not be negative, got -5")
+}
+
+func TestValidateArchive(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		DataDir:     "data",
+	}
+	config.setDefaults()
+	assert.Equal(t, 1, config.Archive.AfterDays)
+	assert.Equal(t, filepath.Join("data", "archive"), config.ArchiveDir())
+	assert.NoError(t, config.Validate())
+
+	config.Archive = Archive{Enabled: true, Dir: "/srv/archive", AfterDays: -1, KeepDays: -2}
+	assert.Equal(t, "/srv/archive", config.ArchiveDir())
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "archive.after_days must not be negative, got -1")
+	assert.Contains(t, err.Error(), "archive.keep_days must not be negative, got -2")
+
+	// Test results may not expire before they are archived
+	config.Archive = Archive{Enabled: true, AfterDays: 7}
+	config.Retention = Retention{SuccessDays: 3, FailureDays: 7, Interval: 60}
+	err = config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "retention.success_days (3) must not be shorter than archive.after_days (7)")
+	assert.NotContains(t, err.Error(), "retention.failure_days")
+	config.Retention.SuccessDays = 7
+	assert.NoError(t, config.Validate())
+}
+
+func TestValidateEncryption(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+		Encryption:  Encryption{Keys: []EncryptionKey{{ID: "2024-06", Key: key}, {ID: "2024-01", Key: key}}},
+	}
+	config.setDefaults()
+	assert.True(t, config.IsEncryptionEnabled())
+	assert.Equal(t, "2024-06", config.Encryption.PrimaryKey)
+	assert.NoError(t, config.Validate())
+
+	config.Encryption = Encryption{
+		Keys: []EncryptionKey{
+			{ID: "", Key: key},
+			{ID: "a", Key: "not base64!"},
+			{ID: "a", Key: base64.StdEncoding.EncodeToString(make([]byte, 20))},
+		},
+		PrimaryKey: "b",
+	}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "encryption.keys[0].id is required")
+	assert.Contains(t, err.Error(), "encryption.keys[1].key: key is not valid base64")
+	assert.Contains(t, err.Error(), `encryption.keys[2].id "a" is used more than once`)
+	assert.Contains(t, err.Error(), "encryption.keys[2].key: key must be 16, 24 or 32 bytes, got 20")
+	assert.Contains(t, err.Error(), `encryption.primary_key "b" does not match any key`)
+
+	// Test keys are secrets and masked when printed
+	config.Encryption = Encryption{Keys: []EncryptionKey{{ID: "k", Key: key}}, PrimaryKey: "k"}
+	assert.Equal(t, "********", config.Masked().Encryption.Keys[0].Key)
+}
+
+func TestValidateRedaction(t *testing.T) {
+	config := &Config{
+		SMTPServer:  "smtp.example.com",
+		SMTPPort:    587,
+		SenderEmail: "noreply@example.com",
+	}
+	config.setDefaults()
+	assert.Equal(t, RedactFull, config.Redaction.Recipient)
+	assert.Equal(t, RedactOmit, config.Redaction.Subject)
+	assert.NoError(t, config.Validate())
+
+	config.Redaction = Redaction{
+		AllowHeaders: []string{"User-Agent", ""},
+		DenyHeaders:  []string{"X-Forwarded-For: x"},
+		Recipient:    "hash",
+		Subject:      RedactMask,
+	}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `redaction.recipient must be full, mask or omit, got "hash"`)
+	assert.Contains(t, err.Error(), `redaction.allow_headers[1] is not a header name: ""`)
+	assert.Contains(t, err.Error(), `redaction.deny_headers[0] is not a header name: "X-Forwarded-For: x"`)
+	assert.NotContains(t, err.Error(), "redaction.subject")
 }
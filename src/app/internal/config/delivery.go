@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxDeliveryRetries bounds delivery.max_retries so that a failing relay
+// cannot hold a delivery goroutine for hours
+const maxDeliveryRetries = 10
+
+// Delivery configures how deliveries that fail with a temporary error are
+// retried. Only failures where the relay cannot have accepted the message
+// are retried: a refused connection, or a 4xx reply.
+type Delivery struct {
+	// MaxRetries is how many times a delivery is tried again. 0 disables
+	// retries.
+	MaxRetries int `json:"max_retries"`
+
+	// RetryDelay is the wait in seconds before the first retry. It doubles
+	// for each retry after that.
+	RetryDelay int `json:"retry_delay"`
+}
+
+// FirstRetryDelay returns the wait before the first retry
+func (d *Delivery) FirstRetryDelay() time.Duration {
+	if d.RetryDelay <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(d.RetryDelay) * time.Second
+}
+
+// setDefaults sets the default retry delay
+func (d *Delivery) setDefaults() {
+	if d.RetryDelay == 0 {
+		d.RetryDelay = 5
+	}
+}
+
+// validate checks the retry settings
+func (d *Delivery) validate() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if d.MaxRetries < 0 || d.MaxRetries > maxDeliveryRetries {
+		addf("delivery.max_retries must be between 0 and %d, got %d", maxDeliveryRetries, d.MaxRetries)
+	}
+	if d.RetryDelay < 0 {
+		addf("delivery.retry_delay must not be negative, got %d", d.RetryDelay)
+	}
+	return problems
+}
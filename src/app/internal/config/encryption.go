@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Encryption configures encryption at rest of email results, debug dumps
+// and archive bundles
+type Encryption struct {
+	// Keys are the key-encryption keys. Old keys stay listed after a
+	// rotation so that records written with them can still be read.
+	Keys []EncryptionKey `json:"keys"`
+
+	// PrimaryKey is the ID of the key new records are written with, by
+	// default the first key
+	PrimaryKey string `json:"primary_key"`
+}
+
+// EncryptionKey is a named AES key
+type EncryptionKey struct {
+	// ID is stored with every record so the key can be found again
+	ID string `json:"id"`
+
+	// Key is the base64 encoded 16, 24 or 32 byte AES key
+	Key string `json:"key" secret:"true"`
+}
+
+// maxKeyIDLength is the longest key ID that fits in a record header
+const maxKeyIDLength = 255
+
+// IsEncryptionEnabled returns true if records are encrypted when written
+func (c *Config) IsEncryptionEnabled() bool {
+	return len(c.Encryption.Keys) > 0
+}
+
+// Bytes decodes the key
+func (k *EncryptionKey) Bytes() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+// setDefaults makes the first key the primary one
+func (e *Encryption) setDefaults() {
+	if e.PrimaryKey == "" && len(e.Keys) > 0 {
+		e.PrimaryKey = e.Keys[0].ID
+	}
+}
+
+// validate checks the keys and that the primary key is one of them
+func (e *Encryption) validate() []string {
+	if len(e.Keys) == 0 {
+		if e.PrimaryKey != "" {
+			return []string{fmt.Sprintf("encryption.primary_key %q is set but no keys are configured", e.PrimaryKey)}
+		}
+		return nil
+	}
+
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	seen := make(map[string]bool)
+	for i, key := range e.Keys {
+		switch {
+		case key.ID == "":
+			addf("encryption.keys[%d].id is required", i)
+		case len(key.ID) > maxKeyIDLength:
+			addf("encryption.keys[%d].id must be at most %d bytes", i, maxKeyIDLength)
+		case seen[key.ID]:
+			addf("encryption.keys[%d].id %q is used more than once", i, key.ID)
+		}
+		seen[key.ID] = true
+
+		if _, err := key.Bytes(); err != nil {
+			addf("encryption.keys[%d].key: %v", i, err)
+		}
+	}
+	if !seen[e.PrimaryKey] {
+		addf("encryption.primary_key %q does not match any key", e.PrimaryKey)
+	}
+	return problems
+}
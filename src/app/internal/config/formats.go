@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeFile decodes config data in the format given by the file extension.
+// YAML and TOML are first converted to JSON so that every format shares the
+// JSON field names and rejects unknown fields.
+func decodeFile(path string, data []byte, config *Config) error {
+	var generic map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+	default:
+		// JSONC is a superset of JSON, so both are read by the same parser
+		return decodeJSONC(data, config)
+	}
+
+	// An empty document decodes to nil
+	if generic == nil {
+		generic = map[string]interface{}{}
+	}
+	converted, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("unsupported value: %w", err)
+	}
+
+	// Positions in the converted JSON mean nothing to the user, so only the
+	// message is kept
+	dec := json.NewDecoder(bytes.NewReader(converted))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
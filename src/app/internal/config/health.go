@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Modes of the SMTP readiness check
+const (
+	SMTPCheckConnect = "connect"
+	SMTPCheckAuth    = "auth"
+	SMTPCheckOff     = "off"
+)
+
+// Health configures the readiness checks of /health/ready
+type Health struct {
+	// SMTPCheck is connect to open a session with each relay and send
+	// EHLO, auth to also authenticate as a sender identity that uses a
+	// password, or off
+	SMTPCheck string `json:"smtp_check"`
+
+	// SMTPCheckInterval is how long in seconds an SMTP check result is
+	// reused, so that frequent probes do not flood the relays
+	SMTPCheckInterval int `json:"smtp_check_interval"`
+
+	// Timeout in seconds for each check
+	Timeout int `json:"timeout"`
+
+	// MaxQueueDepth is the number of emails accepted but not yet sent
+	// above which the service is not ready. 0 disables the check.
+	MaxQueueDepth int `json:"max_queue_depth"`
+}
+
+// CheckTimeout returns the time limit for each check
+func (h *Health) CheckTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// setDefaults sets the default SMTP check mode, interval and timeout
+func (h *Health) setDefaults() {
+	if h.SMTPCheck == "" {
+		h.SMTPCheck = SMTPCheckConnect
+	}
+	if h.SMTPCheckInterval == 0 {
+		h.SMTPCheckInterval = 30
+	}
+	if h.Timeout == 0 {
+		h.Timeout = 5
+	}
+}
+
+// validate checks the readiness settings
+func (h *Health) validate() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch h.SMTPCheck {
+	case "", SMTPCheckConnect, SMTPCheckAuth, SMTPCheckOff:
+	default:
+		addf("health.smtp_check must be connect, auth or off, got %q", h.SMTPCheck)
+	}
+	if h.SMTPCheckInterval < 0 {
+		addf("health.smtp_check_interval must not be negative, got %d", h.SMTPCheckInterval)
+	}
+	if h.Timeout < 0 {
+		addf("health.timeout must not be negative, got %d", h.Timeout)
+	}
+	if h.MaxQueueDepth < 0 {
+		addf("health.max_queue_depth must not be negative, got %d", h.MaxQueueDepth)
+	}
+	return problems
+}
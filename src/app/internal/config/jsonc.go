@@ -0,0 +1,127 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stripJSONC turns JSONC into plain JSON. Line comments, block comments and
+// trailing commas are replaced by spaces so that byte offsets, and therefore
+// line and column numbers, stay the same as in the original input.
+func stripJSONC(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// lastComma is the offset of a comma that may turn out to be trailing
+	lastComma := -1
+
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			// Skip over the string, honoring escapes
+			lastComma = -1
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			start := i
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end == -1 {
+				line, col := position(data, start)
+				return nil, fmt.Errorf("line %d, column %d: unterminated block comment", line, col)
+			}
+			end += i + 4
+			for ; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma != -1 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			// Whitespace keeps a pending comma pending
+		default:
+			lastComma = -1
+		}
+	}
+
+	return out, nil
+}
+
+// decodeJSONC decodes JSONC data into v, rejecting unknown fields. Errors
+// carry the line and column of the offending input.
+func decodeJSONC(data []byte, v interface{}) error {
+	clean, err := stripJSONC(data)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(clean))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return locateError(clean, err)
+	}
+
+	// Only a single top-level value is allowed
+	if _, err := dec.Token(); err != io.EOF {
+		line, col := position(clean, int(dec.InputOffset()))
+		return fmt.Errorf("line %d, column %d: unexpected data after top-level value", line, col)
+	}
+
+	return nil
+}
+
+// locateError prefixes a decoding error with its line and column
+func locateError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	offset := -1
+	switch {
+	// Offsets point just past the offending byte
+	case errors.As(err, &syntaxErr):
+		offset = int(syntaxErr.Offset) - 1
+	case errors.As(err, &typeErr):
+		offset = int(typeErr.Offset) - 1
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder does not report an offset for unknown fields
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		offset = bytes.Index(data, []byte(field))
+		err = fmt.Errorf("unknown field %s", field)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		offset = len(data)
+	}
+
+	if offset < 0 {
+		return err
+	}
+	line, col := position(data, offset)
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// position converts a byte offset into 1-based line and column numbers
+func position(data []byte, offset int) (line, col int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = offset - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// JWTPrincipalPrefix starts the name of every JWT principal, so that a
+// token subject cannot pass for an API key of the same name
+const JWTPrincipalPrefix = "jwt:"
+
+// JWTAuth configures authentication with JWT bearer tokens verified
+// against a JSON Web Key Set
+type JWTAuth struct {
+	// Exactly one of JWKSFile and JWKSURL enables JWT authentication
+	JWKSFile string `json:"jwks_file"`
+	JWKSURL  string `json:"jwks_url"`
+
+	// RefreshInterval is how often in seconds a JWKS URL is fetched again
+	RefreshInterval int `json:"refresh_interval"`
+
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+
+	// Claims holding the principal name, scopes and allowed senders
+	NameClaim    string `json:"name_claim"`
+	ScopeClaim   string `json:"scope_claim"`
+	SendersClaim string `json:"senders_claim"`
+
+	// ScopeMapping maps token scopes to API scopes. When empty, token
+	// scopes are used as API scopes directly.
+	ScopeMapping map[string][]string `json:"scope_mapping"`
+}
+
+// IsJWTAuthEnabled returns true if bearer tokens are accepted
+func (c *Config) IsJWTAuthEnabled() bool {
+	return c.JWT.JWKSFile != "" || c.JWT.JWKSURL != ""
+}
+
+// setDefaults sets default claim names and refresh interval
+func (j *JWTAuth) setDefaults() {
+	if j.RefreshInterval == 0 {
+		j.RefreshInterval = 3600
+	}
+	if j.NameClaim == "" {
+		j.NameClaim = "sub"
+	}
+	if j.ScopeClaim == "" {
+		j.ScopeClaim = "scope"
+	}
+	if j.SendersClaim == "" {
+		j.SendersClaim = "allowed_senders"
+	}
+}
+
+// validate checks the JWT settings when they are enabled
+func (j *JWTAuth) validate() []string {
+	if j.JWKSFile == "" && j.JWKSURL == "" {
+		return nil
+	}
+
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if j.JWKSFile != "" && j.JWKSURL != "" {
+		addf("jwt.jwks_file and jwt.jwks_url are mutually exclusive")
+	}
+	if j.JWKSURL != "" {
+		if u, err := url.Parse(j.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			addf("jwt.jwks_url must be an http or https URL, got %q", j.JWKSURL)
+		}
+	}
+	if j.RefreshInterval < 0 {
+		addf("jwt.refresh_interval must not be negative, got %d", j.RefreshInterval)
+	}
+	if strings.TrimSpace(j.Issuer) == "" {
+		addf("jwt.issuer is required when JWT authentication is enabled")
+	}
+	if strings.TrimSpace(j.Audience) == "" {
+		addf("jwt.audience is required when JWT authentication is enabled")
+	}
+	froms := make([]string, 0, len(j.ScopeMapping))
+	for from := range j.ScopeMapping {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	for _, from := range froms {
+		for _, scope := range j.ScopeMapping[from] {
+			if !ValidScope(scope) {
+				addf("jwt.scope_mapping[%q]: unknown scope %q, expected one of %s", from, scope, strings.Join(Scopes, ", "))
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Logging configures the application log
+type Logging struct {
+	// Level is debug, info, warn or error
+	Level string `json:"level"`
+
+	// Format is text or json
+	Format string `json:"format"`
+}
+
+// SlogLevel returns the configured level, or info if it is invalid
+func (l *Logging) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// setDefaults sets the default level and format
+func (l *Logging) setDefaults() {
+	if l.Level == "" {
+		l.Level = "info"
+	}
+	if l.Format == "" {
+		l.Format = "text"
+	}
+}
+
+// validate checks the level and format
+func (l *Logging) validate() []string {
+	var problems []string
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level must be debug, info, warn or error, got %q", l.Level))
+	}
+	if l.Format != "text" && l.Format != "json" {
+		problems = append(problems, fmt.Sprintf("log.format must be text or json, got %q", l.Format))
+	}
+	return problems
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AllowedIPs restricts routes to client IP ranges, given as IPs or CIDRs.
+// An empty list allows every client.
+type AllowedIPs struct {
+	Send   []string `json:"send"`
+	Status []string `json:"status"`
+	Usage  []string `json:"usage"`
+	Admin  []string `json:"admin"`
+
+	// Metrics restricts the Prometheus /metrics endpoint
+	Metrics []string `json:"metrics"`
+
+	// Health restricts the /health endpoints
+	Health []string `json:"health"`
+}
+
+// ParseCIDR parses an IP range in CIDR notation or a single IP address
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return ipNet, nil
+}
+
+// IPAllowed reports whether ip falls within one of the ranges. An empty
+// list allows every address; unparsable entries match nothing.
+func IPAllowed(ranges []string, ip string) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if ipNet, err := ParseCIDR(r); err == nil && ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCIDRs checks that every entry of a list of IP ranges parses
+func validateCIDRs(name string, ranges []string) []string {
+	var problems []string
+	for _, r := range ranges {
+		if _, err := ParseCIDR(r); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Redaction modes for recipients and subjects
+const (
+	RedactFull = "full"
+	RedactMask = "mask"
+	RedactOmit = "omit"
+)
+
+// Redaction limits the personal data kept in stored results and written
+// to logs
+type Redaction struct {
+	// AllowHeaders, when set, are the only request headers stored with
+	// results
+	AllowHeaders []string `json:"allow_headers"`
+
+	// DenyHeaders are never stored. Credentials such as API keys, bearer
+	// tokens and cookies are never stored either way.
+	DenyHeaders []string `json:"deny_headers"`
+
+	// Recipient is full, mask (j***@example.com) or omit
+	Recipient string `json:"recipient"`
+
+	// Subject is full, mask (the first characters only) or omit
+	Subject string `json:"subject"`
+}
+
+// setDefaults keeps recipients, which results are searched by, and leaves
+// subjects out
+func (r *Redaction) setDefaults() {
+	if r.Recipient == "" {
+		r.Recipient = RedactFull
+	}
+	if r.Subject == "" {
+		r.Subject = RedactOmit
+	}
+}
+
+// validate checks the modes and header names
+func (r *Redaction) validate() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, p := range []struct {
+		name string
+		mode string
+	}{
+		{"recipient", r.Recipient},
+		{"subject", r.Subject},
+	} {
+		switch p.mode {
+		case RedactFull, RedactMask, RedactOmit:
+		default:
+			addf("redaction.%s must be full, mask or omit, got %q", p.name, p.mode)
+		}
+	}
+	for _, list := range []struct {
+		name    string
+		headers []string
+	}{
+		{"allow_headers", r.AllowHeaders},
+		{"deny_headers", r.DenyHeaders},
+	} {
+		for i, header := range list.headers {
+			if strings.TrimSpace(header) == "" || strings.ContainsAny(header, " :") {
+				addf("redaction.%s[%d] is not a header name: %q", list.name, i, header)
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention configures how long records are kept before the janitor
+// removes them. A period of 0 keeps records forever.
+type Retention struct {
+	SuccessDays int `json:"success_days"`
+	FailureDays int `json:"failure_days"`
+	DebugDays   int `json:"debug_days"`
+
+	// Interval is how often in minutes the janitor runs
+	Interval int `json:"interval"`
+}
+
+// IsRetentionEnabled returns true if any record type expires
+func (c *Config) IsRetentionEnabled() bool {
+	r := c.Retention
+	return r.SuccessDays > 0 || r.FailureDays > 0 || r.DebugDays > 0
+}
+
+// JanitorInterval returns the time between janitor runs
+func (r *Retention) JanitorInterval() time.Duration {
+	if r.Interval <= 0 {
+		return time.Hour
+	}
+	return time.Duration(r.Interval) * time.Minute
+}
+
+// setDefaults sets the default janitor interval
+func (r *Retention) setDefaults() {
+	if r.Interval == 0 {
+		r.Interval = 60
+	}
+}
+
+// validate checks the retention periods and interval
+func (r *Retention) validate() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, p := range []struct {
+		name string
+		days int
+	}{
+		{"success_days", r.SuccessDays},
+		{"failure_days", r.FailureDays},
+		{"debug_days", r.DebugDays},
+	} {
+		if p.days < 0 {
+			addf("retention.%s must not be negative, got %d", p.name, p.days)
+		}
+	}
+	if r.Interval < 0 {
+		addf("retention.interval must not be negative, got %d", r.Interval)
+	}
+	return problems
+}
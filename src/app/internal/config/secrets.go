@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Secret reference prefixes accepted in fields tagged secret:"true"
+const (
+	fileRefPrefix = "file:"
+	envRefPrefix  = "env:"
+)
+
+// secretMask replaces secret values in printed configuration
+const secretMask = "********"
+
+// walkSecrets calls fn for every string field tagged secret:"true",
+// descending into nested structs and lists. name is the dotted JSON path of
+// the field. All errors returned by fn are collected.
+func walkSecrets(v reflect.Value, path string, fn func(field reflect.Value, name string) error) error {
+	var errs []error
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return walkSecrets(v.Elem(), path, fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, walkSecrets(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				errs = append(errs, fn(v.Field(i), name))
+				continue
+			}
+			errs = append(errs, walkSecrets(v.Field(i), name, fn))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolveSecrets replaces file: and env: references in every secret field.
+// All unresolvable references are reported.
+func resolveSecrets(v reflect.Value, path string, lookup func(string) (string, bool)) error {
+	return walkSecrets(v, path, func(field reflect.Value, name string) error {
+		resolved, err := resolveReference(field.String(), lookup)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		field.SetString(resolved)
+		return nil
+	})
+}
+
+// Masked returns a deep copy of the configuration with every non-empty
+// secret replaced by a fixed mask, suitable for printing or logging
+func (c *Config) Masked() *Config {
+	// A JSON round trip gives a deep copy of nested lists
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(fmt.Sprintf("config is not serializable: %v", err))
+	}
+	masked := &Config{}
+	if err := json.Unmarshal(data, masked); err != nil {
+		panic(fmt.Sprintf("config is not serializable: %v", err))
+	}
+
+	walkSecrets(reflect.ValueOf(masked), "", func(field reflect.Value, _ string) error {
+		if field.String() != "" {
+			field.SetString(secretMask)
+		}
+		return nil
+	})
+	return masked
+}
+
+// resolveReference returns the secret a value refers to. Values of the form
+// file:/path are read from the file with trailing newlines removed, and
+// env:NAME are read from the environment. Other values are returned as is.
+func resolveReference(value string, lookup func(string) (string, bool)) (string, error) {
+	switch {
+	case strings.HasPrefix(value, fileRefPrefix):
+		return readSecretFile(strings.TrimPrefix(value, fileRefPrefix))
+	case strings.HasPrefix(value, envRefPrefix):
+		name := strings.TrimPrefix(value, envRefPrefix)
+		secret, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// readSecretFile reads a secret from a file such as a Docker or Kubernetes
+// secret mount, dropping the trailing newline most editors add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
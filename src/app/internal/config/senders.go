@@ -0,0 +1,128 @@
+package config
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultSenderID names the identity built from the top-level sender_* fields
+const DefaultSenderID = "default"
+
+// SenderIdentity is a named From address with its own credentials and an
+// optional relay
+type SenderIdentity struct {
+	ID           string `json:"id"`
+	Email        string `json:"email"`
+	EmailDisplay string `json:"email_display"`
+	Domain       string `json:"domain"`
+	UsePassword  bool   `json:"use_password"`
+	Password     string `json:"password" secret:"true"`
+
+	// Relay overrides the top-level SMTP settings for this identity
+	Relay *Relay `json:"relay,omitempty"`
+}
+
+// Relay holds the settings for connecting to an SMTP server
+type Relay struct {
+	SMTPServer string `json:"smtp_server"`
+	SMTPPort   int    `json:"smtp_port"`
+	UseSSL     bool   `json:"use_ssl"`
+	UseTLS     bool   `json:"use_tls"`
+}
+
+// Address returns the relay's host:port
+func (r *Relay) Address() string {
+	return net.JoinHostPort(r.SMTPServer, strconv.Itoa(r.SMTPPort))
+}
+
+// GetDisplayEmail returns the display email or falls back to the address
+func (s *SenderIdentity) GetDisplayEmail() string {
+	if strings.TrimSpace(s.EmailDisplay) != "" {
+		return s.EmailDisplay
+	}
+	return s.Email
+}
+
+// defaultSender returns the identity described by the top-level sender
+// fields, or nil if sender_email is not set
+func (c *Config) defaultSender() *SenderIdentity {
+	if strings.TrimSpace(c.SenderEmail) == "" {
+		return nil
+	}
+	return &SenderIdentity{
+		ID:           DefaultSenderID,
+		Email:        c.SenderEmail,
+		EmailDisplay: c.SenderEmailDisplay,
+		Domain:       c.SenderDomain,
+		UsePassword:  c.UsePassword,
+		Password:     c.SenderPassword,
+	}
+}
+
+// globalRelay returns the top-level SMTP settings
+func (c *Config) globalRelay() *Relay {
+	return &Relay{
+		SMTPServer: c.SMTPServer,
+		SMTPPort:   c.SMTPPort,
+		UseSSL:     c.UseSSL,
+		UseTLS:     c.UseTLS,
+	}
+}
+
+// SenderIdentities returns every configured identity, including the one
+// built from the top-level sender fields
+func (c *Config) SenderIdentities() []*SenderIdentity {
+	var identities []*SenderIdentity
+	if def := c.defaultSender(); def != nil {
+		identities = append(identities, def)
+	}
+	for i := range c.Senders {
+		identities = append(identities, &c.Senders[i])
+	}
+	return identities
+}
+
+// FindSender returns a copy of the identity with the given ID, or the
+// default identity when id is empty. The copy always has its relay set,
+// falling back to the top-level SMTP settings.
+func (c *Config) FindSender(id string) (*SenderIdentity, bool) {
+	if id == "" {
+		id = c.DefaultSender
+	}
+	if id == "" {
+		id = DefaultSenderID
+	}
+
+	for _, identity := range c.SenderIdentities() {
+		if identity.ID == id {
+			return c.complete(identity), true
+		}
+	}
+	return nil, false
+}
+
+// FindSenderByEmail returns a copy of the identity sending as the given
+// address, compared case-insensitively
+func (c *Config) FindSenderByEmail(email string) (*SenderIdentity, bool) {
+	for _, identity := range c.SenderIdentities() {
+		if strings.EqualFold(identity.Email, email) {
+			return c.complete(identity), true
+		}
+	}
+	return nil, false
+}
+
+// complete returns a copy of identity with its relay and domain filled in
+func (c *Config) complete(identity *SenderIdentity) *SenderIdentity {
+	filled := *identity
+	if filled.Relay == nil {
+		filled.Relay = c.globalRelay()
+	}
+	if filled.Domain == "" {
+		if at := strings.LastIndex(filled.Email, "@"); at != -1 {
+			filled.Domain = filled.Email[at+1:]
+		}
+	}
+	return &filled
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Storage backends for email results
+const (
+	StorageFilesystem = "filesystem"
+	StorageSQLite     = "sqlite"
+)
+
+// Storage selects where email results are kept
+type Storage struct {
+	// Backend is filesystem, one JSON file per email under the data
+	// directory, or sqlite, one indexed database file
+	Backend string `json:"backend"`
+
+	// SQLitePath is the database file of the sqlite backend
+	SQLitePath string `json:"sqlite_path"`
+}
+
+// ResultDBPath returns the SQLite database of email results, which
+// defaults to emails.db in the data directory
+func (c *Config) ResultDBPath() string {
+	if c.Storage.SQLitePath != "" {
+		return c.Storage.SQLitePath
+	}
+	return filepath.Join(c.DataDir, "emails.db")
+}
+
+// setDefaults sets the default backend
+func (s *Storage) setDefaults() {
+	if s.Backend == "" {
+		s.Backend = StorageFilesystem
+	}
+}
+
+// validate checks the backend name
+func (s *Storage) validate() []string {
+	switch s.Backend {
+	case "", StorageFilesystem, StorageSQLite:
+		return nil
+	}
+	return []string{fmt.Sprintf("storage.backend must be filesystem or sqlite, got %q", s.Backend)}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// CertPrincipalPrefix starts the name of every client certificate
+// principal, so that a certificate cannot pass for an API key of the same
+// name
+const CertPrincipalPrefix = "cert:"
+
+// TLSConfig enables HTTPS and, with a client CA, mutual TLS
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile holds the PEM certificates that sign accepted client
+	// certificates
+	ClientCAFile string `json:"client_ca_file"`
+
+	// RequireClientCert rejects connections without a valid client
+	// certificate. Otherwise certificates are verified when presented.
+	RequireClientCert bool `json:"require_client_cert"`
+
+	// ClientCerts map client certificate subjects to principals
+	ClientCerts []ClientCert `json:"client_certs"`
+}
+
+// ClientCert grants scopes to callers presenting a matching certificate
+type ClientCert struct {
+	// Subject is the certificate's common name or its full distinguished
+	// name, e.g. "CN=billing,O=Example"
+	Subject string `json:"subject"`
+
+	// Name identifies the caller (default: the subject)
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+
+	// Sender identities the caller may use (empty means all)
+	AllowedSenders []string `json:"allowed_senders"`
+
+	// Client IP ranges the caller may connect from (empty means all)
+	AllowedIPs []string `json:"allowed_ips"`
+
+	// Overrides of the rate_limit defaults (zero keeps the default)
+	RateLimitPerMinute int `json:"rate_limit_per_minute"`
+	DailyQuota         int `json:"daily_quota"`
+	MonthlyQuota       int `json:"monthly_quota"`
+}
+
+// Matches reports whether cert has the configured subject
+func (cc *ClientCert) Matches(cert *x509.Certificate) bool {
+	return cc.Subject == cert.Subject.CommonName || cc.Subject == cert.Subject.String()
+}
+
+// PrincipalName returns the name identifying the caller: the configured
+// name or the subject, with the cert: prefix
+func (cc *ClientCert) PrincipalName() string {
+	if cc.Name != "" {
+		return CertPrincipalPrefix + cc.Name
+	}
+	return CertPrincipalPrefix + cc.Subject
+}
+
+// IsTLSEnabled returns true if the API is served over HTTPS
+func (c *Config) IsTLSEnabled() bool {
+	return c.TLS.CertFile != ""
+}
+
+// IsClientCertAuthEnabled returns true if client certificates map to
+// principals
+func (c *Config) IsClientCertAuthEnabled() bool {
+	return len(c.TLS.ClientCerts) > 0
+}
+
+// validate checks the TLS settings and the sender identities referenced
+// by client certificates
+func (t *TLSConfig) validate(senderIDs map[string]bool) []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		addf("tls.cert_file and tls.key_file must be set together")
+	}
+	if t.ClientCAFile != "" && t.CertFile == "" {
+		addf("tls.client_ca_file requires tls.cert_file")
+	}
+	if t.RequireClientCert && t.ClientCAFile == "" {
+		addf("tls.require_client_cert requires tls.client_ca_file")
+	}
+	if len(t.ClientCerts) > 0 && t.ClientCAFile == "" {
+		addf("tls.client_certs requires tls.client_ca_file")
+	}
+
+	subjects := make(map[string]bool)
+	for i, cc := range t.ClientCerts {
+		name := fmt.Sprintf("tls.client_certs[%d]", i)
+		switch {
+		case strings.TrimSpace(cc.Subject) == "":
+			addf("%s.subject is required", name)
+		case subjects[cc.Subject]:
+			addf("%s.subject %q is already used", name, cc.Subject)
+		default:
+			subjects[cc.Subject] = true
+			name = fmt.Sprintf("tls.client_certs[%q]", cc.Subject)
+		}
+
+		if len(cc.Scopes) == 0 {
+			addf("%s.scopes must list at least one of %s", name, strings.Join(Scopes, ", "))
+		}
+		for _, scope := range cc.Scopes {
+			if !ValidScope(scope) {
+				addf("%s.scopes: unknown scope %q, expected one of %s", name, scope, strings.Join(Scopes, ", "))
+			}
+		}
+		for _, id := range cc.AllowedSenders {
+			if !senderIDs[id] {
+				addf("%s.allowed_senders: %q does not name a sender identity", name, id)
+			}
+		}
+		problems = append(problems, validateCIDRs(name+".allowed_ips", cc.AllowedIPs)...)
+		if cc.RateLimitPerMinute < 0 || cc.DailyQuota < 0 || cc.MonthlyQuota < 0 {
+			addf("%s: rate_limit_per_minute, daily_quota and monthly_quota must not be negative", name)
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// otlpTracesPath is where OTLP/HTTP collectors receive spans
+const otlpTracesPath = "/v1/traces"
+
+// Tracing configures OpenTelemetry tracing
+type Tracing struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://localhost:4318/v1/traces. A URL without a path gets
+	// /v1/traces. Tracing is disabled when it is empty.
+	Endpoint string `json:"endpoint"`
+
+	// ServiceName identifies this service in traces
+	ServiceName string `json:"service_name"`
+
+	// SampleRatio is the fraction of new traces that are recorded (default
+	// 1; 0 records none). Traces started upstream follow the caller's
+	// sampling decision.
+	SampleRatio *float64 `json:"sample_ratio"`
+}
+
+// TracesURL returns the URL spans are exported to
+func (t *Tracing) TracesURL() string {
+	u, err := url.Parse(t.Endpoint)
+	if err != nil || strings.Trim(u.Path, "/") != "" {
+		return t.Endpoint
+	}
+	u.Path = otlpTracesPath
+	return u.String()
+}
+
+// Ratio returns the sample ratio, which is 1 when not set
+func (t *Tracing) Ratio() float64 {
+	if t.SampleRatio == nil {
+		return 1
+	}
+	return *t.SampleRatio
+}
+
+// IsTracingEnabled returns true if spans are exported
+func (c *Config) IsTracingEnabled() bool {
+	return c.Tracing.Endpoint != ""
+}
+
+// setDefaults sets the default service name and sample ratio
+func (t *Tracing) setDefaults() {
+	if t.ServiceName == "" {
+		t.ServiceName = "smtogo"
+	}
+	if t.SampleRatio == nil {
+		ratio := 1.0
+		t.SampleRatio = &ratio
+	}
+}
+
+// validate checks the tracing settings when they are enabled
+func (t *Tracing) validate() []string {
+	if t.Endpoint == "" {
+		return nil
+	}
+
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		addf("tracing.endpoint must be an http or https URL, got %q", t.Endpoint)
+	}
+	if ratio := t.Ratio(); ratio < 0 || ratio > 1 {
+		addf("tracing.sample_ratio must be between 0 and 1, got %g", ratio)
+	}
+	return problems
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration for missing, out-of-range and
+// contradictory values. It reports all problems at once as a
+// *ValidationError, or nil when the configuration is usable.
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// API settings
+	if !validPort(c.Port) {
+		addf("port must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		addf("bind_address must be an IP address, got %q", c.BindAddress)
+	}
+	if c.Listen != "" {
+		network, addr := c.ListenAddress()
+		if _, _, err := net.SplitHostPort(addr); network == "tcp" && err != nil {
+			addf("listen must be host:port or unix:/path, got %q", c.Listen)
+		}
+		if network == "unix" && addr == "" {
+			addf("listen must name a socket path after unix:")
+		}
+	}
+	if strings.TrimSpace(c.DataDir) == "" {
+		addf("data_dir is required")
+	}
+	if rl := c.RateLimit; rl.PerIPPerMinute < 0 || rl.PerKeyPerMinute < 0 || rl.DailyQuota < 0 || rl.MonthlyQuota < 0 {
+		addf("rate_limit values must not be negative")
+	}
+	if c.SignatureMaxSkew < 0 {
+		addf("signature_max_skew must not be negative, got %d", c.SignatureMaxSkew)
+	}
+	problems = append(problems, validateCIDRs("trusted_proxies", c.TrustedProxies)...)
+	problems = append(problems, validateCIDRs("allowed_ips.send", c.AllowedIPs.Send)...)
+	problems = append(problems, validateCIDRs("allowed_ips.status", c.AllowedIPs.Status)...)
+	problems = append(problems, validateCIDRs("allowed_ips.usage", c.AllowedIPs.Usage)...)
+	problems = append(problems, validateCIDRs("allowed_ips.admin", c.AllowedIPs.Admin)...)
+	problems = append(problems, validateCIDRs("allowed_ips.metrics", c.AllowedIPs.Metrics)...)
+	problems = append(problems, validateCIDRs("allowed_ips.health", c.AllowedIPs.Health)...)
+	problems = append(problems, validateCIDRs("api_key_allowed_ips", c.APIKeyAllowedIPs)...)
+
+	// SMTP settings
+	if strings.TrimSpace(c.SMTPServer) == "" {
+		addf("smtp_server is required")
+	}
+	if !validPort(c.SMTPPort) {
+		addf("smtp_port must be between 1 and 65535, got %d", c.SMTPPort)
+	}
+	if c.UseSSL && c.UseTLS {
+		addf("use_ssl and use_tls are mutually exclusive: use_ssl is implicit TLS (usually port 465), use_tls is STARTTLS (usually port 587)")
+	}
+	if c.UsePassword && c.SenderPassword == "" {
+		addf("sender_password is required when use_password is true")
+	}
+
+	// Email limits
+	if c.MaxLenRecipientEmail < 0 {
+		addf("max_len_recipient_email must not be negative, got %d", c.MaxLenRecipientEmail)
+	}
+	if c.MaxLenSubject < 0 {
+		addf("max_len_subject must not be negative, got %d", c.MaxLenSubject)
+	}
+	if c.MaxLenBody < 0 {
+		addf("max_len_body must not be negative, got %d", c.MaxLenBody)
+	}
+
+	// Sender settings
+	if strings.TrimSpace(c.SenderEmail) == "" {
+		if len(c.Senders) == 0 {
+			addf("sender_email is required unless senders are configured")
+		}
+	} else if !validBareAddress(c.SenderEmail) {
+		addf("sender_email must be a bare address like user@example.com, got %q", c.SenderEmail)
+	}
+	if strings.TrimSpace(c.SenderEmailDisplay) != "" {
+		if _, err := mail.ParseAddress(c.SenderEmailDisplay); err != nil {
+			addf("sender_email_display must be an address like \"Name <user@example.com>\", got %q", c.SenderEmailDisplay)
+		}
+	}
+
+	problems = append(problems, c.validateSenders()...)
+	problems = append(problems, c.JWT.validate()...)
+	problems = append(problems, c.TLS.validate(c.senderIDs())...)
+	problems = append(problems, c.Log.validate()...)
+	problems = append(problems, c.Tracing.validate()...)
+	problems = append(problems, c.Delivery.validate()...)
+	problems = append(problems, c.Health.validate()...)
+	problems = append(problems, c.Storage.validate()...)
+	problems = append(problems, c.Retention.validate()...)
+	problems = append(problems, c.Archive.validate(c.Retention)...)
+	problems = append(problems, c.Encryption.validate()...)
+	problems = append(problems, c.Redaction.validate()...)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validPort reports whether p is a usable TCP port
+func validPort(p int) bool {
+	return p >= 1 && p <= 65535
+}
+
+// validBareAddress reports whether s is an email address without a display name
+func validBareAddress(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s
+}
+
+// validateSenders checks the named sender identities and the references
+// to them
+func (c *Config) validateSenders() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	ids := make(map[string]bool)
+	if c.defaultSender() != nil {
+		ids[DefaultSenderID] = true
+	}
+
+	for i, identity := range c.Senders {
+		name := fmt.Sprintf("senders[%d]", i)
+		switch {
+		case strings.TrimSpace(identity.ID) == "":
+			addf("%s.id is required", name)
+		case ids[identity.ID]:
+			addf("%s.id %q is already used", name, identity.ID)
+		default:
+			ids[identity.ID] = true
+			name = fmt.Sprintf("senders[%q]", identity.ID)
+		}
+
+		if !validBareAddress(identity.Email) {
+			addf("%s.email must be a bare address like user@example.com, got %q", name, identity.Email)
+		}
+		if strings.TrimSpace(identity.EmailDisplay) != "" {
+			if _, err := mail.ParseAddress(identity.EmailDisplay); err != nil {
+				addf("%s.email_display must be an address like \"Name <user@example.com>\", got %q", name, identity.EmailDisplay)
+			}
+		}
+		if identity.UsePassword && identity.Password == "" {
+			addf("%s.password is required when use_password is true", name)
+		}
+
+		if relay := identity.Relay; relay != nil {
+			if strings.TrimSpace(relay.SMTPServer) == "" {
+				addf("%s.relay.smtp_server is required", name)
+			}
+			if !validPort(relay.SMTPPort) {
+				addf("%s.relay.smtp_port must be between 1 and 65535, got %d", name, relay.SMTPPort)
+			}
+			if relay.UseSSL && relay.UseTLS {
+				addf("%s.relay: use_ssl and use_tls are mutually exclusive", name)
+			}
+		}
+	}
+
+	if c.DefaultSender != "" && !ids[c.DefaultSender] {
+		addf("default_sender %q does not name a sender identity", c.DefaultSender)
+	}
+	if c.DefaultSender == "" && !ids[DefaultSenderID] && len(c.Senders) > 0 {
+		addf("default_sender is required when sender_email is not set")
+	}
+	for _, id := range c.APIKeySenders {
+		if !ids[id] {
+			addf("api_key_senders: %q does not name a sender identity", id)
+		}
+	}
+
+	return append(problems, c.validateAPIKeys(ids)...)
+}
+
+// senderIDs returns the IDs of the configured sender identities
+func (c *Config) senderIDs() map[string]bool {
+	ids := make(map[string]bool)
+	if c.defaultSender() != nil {
+		ids[DefaultSenderID] = true
+	}
+	for _, identity := range c.Senders {
+		if identity.ID != "" {
+			ids[identity.ID] = true
+		}
+	}
+	return ids
+}
+
+// validateAPIKeys checks the named API keys against the known sender
+// identities
+func (c *Config) validateAPIKeys(senderIDs map[string]bool) []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := make(map[string]bool)
+	if strings.TrimSpace(c.APIKey) != "" {
+		names[LegacyAPIKeyName] = true
+	}
+	for _, scope := range c.APIKeyScopes {
+		if !ValidScope(scope) {
+			addf("api_key_scopes: unknown scope %q, expected one of %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+	secrets := make(map[string]bool)
+
+	for i, key := range c.APIKeys {
+		name := fmt.Sprintf("api_keys[%d]", i)
+		switch {
+		case strings.TrimSpace(key.Name) == "":
+			addf("%s.name is required", name)
+		case names[key.Name]:
+			addf("%s.name %q is already used", name, key.Name)
+		case ReservedKeyName(key.Name):
+			addf("%s.name %q uses a prefix reserved for other principals", name, key.Name)
+		default:
+			names[key.Name] = true
+			name = fmt.Sprintf("api_keys[%q]", key.Name)
+		}
+
+		switch {
+		case strings.TrimSpace(key.Key) == "":
+			addf("%s.key is required", name)
+		case key.Key == c.APIKey || secrets[key.Key]:
+			addf("%s.key must be unique", name)
+		default:
+			secrets[key.Key] = true
+		}
+
+		if len(key.Scopes) == 0 {
+			addf("%s.scopes must list at least one of %s", name, strings.Join(Scopes, ", "))
+		}
+		for _, scope := range key.Scopes {
+			if !ValidScope(scope) {
+				addf("%s.scopes: unknown scope %q, expected one of %s", name, scope, strings.Join(Scopes, ", "))
+			}
+		}
+		for _, id := range key.AllowedSenders {
+			if !senderIDs[id] {
+				addf("%s.allowed_senders: %q does not name a sender identity", name, id)
+			}
+		}
+		problems = append(problems, validateCIDRs(name+".allowed_ips", key.AllowedIPs)...)
+		if key.RateLimitPerMinute < 0 || key.DailyQuota < 0 || key.MonthlyQuota < 0 {
+			addf("%s: rate_limit_per_minute, daily_quota and monthly_quota must not be negative", name)
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Watch polls the file at path and calls onChange whenever its content
+// changes, until ctx is cancelled. Polling by content rather than relying on
+// file events also catches Kubernetes ConfigMap updates, which swap a
+// symlink instead of writing the file. A missing file counts as empty, so a
+// file appearing later is reported as a change.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last := fileDigest(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if digest := fileDigest(path); digest != last {
+				last = digest
+				onChange()
+			}
+		}
+	}
+}
+
+// fileDigest returns the SHA-256 of the file content, or the zero value if
+// the file cannot be read
+func fileDigest(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
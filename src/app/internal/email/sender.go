@@ -1,54 +1,118 @@
 package email
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/logging"
+	"github.com/hnrobert/smtogo/internal/metrics"
 	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+	"github.com/hnrobert/smtogo/internal/tracing"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gomail.v2"
 )
 
 // Sender handles email sending operations
 type Sender struct {
-	config *config.Config
+	config  atomic.Pointer[config.Config]
+	results store.Store
+	keys    *encryption.Keyring
+
+	// rootCAs verifies relay certificates instead of the system roots
+	// when set
+	rootCAs *x509.CertPool
 }
 
-// NewSender creates a new email sender
-func NewSender(cfg *config.Config) *Sender {
-	return &Sender{
-		config: cfg,
-	}
+// NewSender creates a new email sender that records results in results.
+// Debug dumps are encrypted with keys unless it is nil.
+func NewSender(cfg *config.Config, results store.Store, keys *encryption.Keyring) *Sender {
+	s := &Sender{results: results, keys: keys}
+	s.config.Store(cfg)
+	return s
+}
+
+// SetConfig atomically replaces the configuration. Sends already in
+// progress finish with the configuration they started with.
+func (s *Sender) SetConfig(cfg *config.Config) {
+	s.config.Store(cfg)
 }
 
-// SendEmail sends an email with optional attachments
-func (s *Sender) SendEmail(req *models.EmailRequest, emailID, clientIP string, headers map[string]string, attachmentNames []string) error {
+// SendEmail sends an email with optional attachments. result carries the
+// fields known when the request was accepted (ID, client IP, headers, API
+// key name); status, detail and timestamp are filled in here.
+func (s *Sender) SendEmail(ctx context.Context, req *models.EmailRequest, result models.EmailResult, attachmentNames []string) error {
+	// Use one configuration snapshot for the whole send
+	cfg := s.config.Load()
+	emailID := result.EmailID
+	logger := logging.FromContext(ctx)
+
+	// Continue the trace of the request that queued the email
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.WithTraceParent(ctx, result.TraceParent)
+	}
+	ctx, span := tracing.Start(ctx, "deliver email", trace.WithAttributes(
+		attribute.String("email_id", emailID),
+		attribute.String("sender_id", req.SenderID),
+	))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	// Calculate message length (approximate)
+	result.MessageLength = len(req.Subject) + len(req.Body) + len(req.RecipientEmail)
+	result.SenderID = req.SenderID
+	result.Recipient = logging.RedactRecipient(cfg.Redaction, req.RecipientEmail)
+	result.Subject = logging.RedactSubject(cfg.Redaction, req.Subject)
+
+	// SMTP errors often quote the recipient
+	scrub := func(err error) string {
+		return logging.ScrubRecipient(cfg.Redaction, err.Error(), req.RecipientEmail)
+	}
+
+	// The identity may have been removed by a config reload since the
+	// request was accepted
+	identity, ok := cfg.FindSender(req.SenderID)
+	if !ok {
+		err = fmt.Errorf("sender identity %q is not configured", req.SenderID)
+		metrics.EmailsFailed.WithLabelValues("", req.SenderID).Inc()
+		logger.Error("Failed to send email", "error", scrub(err))
+		s.saveEmailResult(ctx, result, "failure", "Failed to send email: "+scrub(err))
+		return err
+	}
+	result.SenderID = identity.ID
+
 	// Create email message
 	m := gomail.NewMessage()
 
 	// Set headers
 	// Fix 553 error by ensuring envelope sender matches SMTP auth user
 	// This matches the behavior of Python's smtplib
-	if s.config.UsePassword {
+	if identity.UsePassword {
 		// When using SMTP authentication, envelope sender must match auth user
-		displayEmail := s.config.GetDisplayEmail()
-		if displayEmail != s.config.SenderEmail {
+		displayEmail := identity.GetDisplayEmail()
+		if displayEmail != identity.Email {
 			// Use SetAddressHeader to set envelope sender to auth email but display custom name
-			m.SetAddressHeader("From", s.config.SenderEmail, displayEmail)
+			m.SetAddressHeader("From", identity.Email, displayEmail)
 		} else {
-			m.SetHeader("From", s.config.SenderEmail)
+			m.SetHeader("From", identity.Email)
 		}
 	} else {
 		// For unauthenticated SMTP (like maildev), use display email
-		m.SetHeader("From", s.config.GetDisplayEmail())
+		m.SetHeader("From", identity.GetDisplayEmail())
 	}
 	m.SetHeader("To", req.RecipientEmail)
 	m.SetHeader("Subject", req.Subject)
-	m.SetHeader("Message-ID", fmt.Sprintf("<%s@%s>", emailID, s.config.SenderDomain))
+	m.SetHeader("Message-ID", fmt.Sprintf("<%s@%s>", emailID, identity.Domain))
 
 	// Set body
 	if req.BodyType == "html" {
@@ -59,107 +123,115 @@ func (s *Sender) SendEmail(req *models.EmailRequest, emailID, clientIP string, h
 
 	// Attachments are not supported in this version
 
-	// Calculate message length (approximate)
-	messageLength := len(req.Subject) + len(req.Body) + len(req.RecipientEmail)
-
-	// Send email
-	if err := s.sendMessage(m); err != nil {
-		s.saveEmailResult(emailID, "failure", fmt.Sprintf("Failed to send email: %v", err), clientIP, headers, messageLength)
+	// Send email, retrying temporary failures
+	span.SetAttributes(attribute.String("smtp.relay", identity.Relay.Address()))
+	delay := cfg.Delivery.FirstRetryDelay()
+	for retry := 1; ; retry++ {
+		err = s.sendMessage(ctx, identity, m)
+		if err == nil || retry > cfg.Delivery.MaxRetries || !temporary(err) {
+			break
+		}
+		metrics.EmailsRetried.WithLabelValues(identity.Relay.Address(), identity.ID).Inc()
+		logger.Warn("Retrying email", "relay", identity.Relay.Address(), "sender_id", identity.ID, "retry", retry, "delay", delay, "error", scrub(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay *= 2
+	}
+	if err != nil {
+		metrics.EmailsFailed.WithLabelValues(identity.Relay.Address(), identity.ID).Inc()
+		logger.Error("Failed to send email", "relay", identity.Relay.Address(), "sender_id", identity.ID, "error", scrub(err))
+		s.saveEmailResult(ctx, result, "failure", "Failed to send email: "+scrub(err))
 		return err
 	}
+	metrics.EmailsSent.WithLabelValues(identity.Relay.Address(), identity.ID).Inc()
+	logger.Info("Email sent", "relay", identity.Relay.Address(), "sender_id", identity.ID)
 
 	// Save success result
-	s.saveEmailResult(emailID, "success", "Email sent successfully", clientIP, headers, messageLength)
+	s.saveEmailResult(ctx, result, "success", "Email sent successfully")
 
 	// Save debug email if requested
 	if req.Debug {
-		s.saveDebugEmail(emailID, m, req)
+		s.saveDebugEmail(ctx, cfg, emailID, m, req)
 	}
 
 	return nil
 }
 
-// sendMessage sends the email message via SMTP
-func (s *Sender) sendMessage(m *gomail.Message) error {
-	// Create SMTP dialer
-	d := gomail.NewDialer(s.config.SMTPServer, s.config.SMTPPort, s.config.SenderEmail, s.config.SenderPassword)
+// saveEmailResult records the email sending result in the result store
+func (s *Sender) saveEmailResult(ctx context.Context, result models.EmailResult, status, detail string) {
+	result.Status = status
+	result.Detail = detail
+	result.Timestamp = time.Now().Format(time.RFC3339)
 
-	// Configure TLS/SSL
-	if s.config.UseSSL {
-		d.SSL = true
-	}
-	if s.config.UseTLS {
-		d.TLSConfig = nil // Use default TLS config
+	if err := s.results.Save(ctx, &result); err != nil {
+		logging.FromContext(ctx).Error("Failed to save email result", "error", err)
 	}
-
-	// Disable authentication if not required
-	if !s.config.UsePassword {
-		d.Username = ""
-		d.Password = ""
-	}
-
-	// Send the message
-	return d.DialAndSend(m)
 }
 
-// saveEmailResult saves the email sending result to a JSON file
-func (s *Sender) saveEmailResult(emailID, status, detail, clientIP string, headers map[string]string, messageLength int) {
-	result := models.EmailResult{
-		EmailID:       emailID,
-		Status:        status,
-		Detail:        detail,
-		Timestamp:     time.Now().Format(time.RFC3339),
-		ClientIP:      clientIP,
-		Headers:       headers,
-		MessageLength: messageLength,
-	}
-
-	// Create directory structure
+// saveDebugEmail saves the raw email message for debugging
+func (s *Sender) saveDebugEmail(ctx context.Context, cfg *config.Config, emailID string, m *gomail.Message, req *models.EmailRequest) {
+	logger := logging.FromContext(ctx)
+	// Create debug directory
 	dateStr := time.Now().Format("2006-01-02")
-	statusDir := "success"
-	if status != "success" {
-		statusDir = "failure"
-	}
-	dirPath := filepath.Join("data", dateStr, statusDir)
+	dirPath := filepath.Join(cfg.DataDir, dateStr, "debug")
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		fmt.Printf("Failed to create directory %s: %v\n", dirPath, err)
+		logger.Error("Failed to create debug directory", "path", dirPath, "error", err)
 		return
 	}
 
-	// Save result to file
-	filePath := filepath.Join(dirPath, fmt.Sprintf("%s.json", emailID))
-	data, err := json.MarshalIndent(result, "", "    ")
+	// Render the message, sealed when encrypting
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		logger.Error("Failed to render debug email", "error", err)
+		return
+	}
+	data, err := s.keys.Seal(buf.Bytes())
 	if err != nil {
-		fmt.Printf("Failed to marshal email result: %v\n", err)
+		logger.Error("Failed to encrypt debug email", "error", err)
 		return
 	}
 
+	// Save message to file
+	filePath := filepath.Join(dirPath, fmt.Sprintf("%s_email.txt", emailID))
 	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		fmt.Printf("Failed to save email result to %s: %v\n", filePath, err)
+		logger.Error("Failed to write debug email", "path", filePath, "error", err)
 	}
 }
 
-// saveDebugEmail saves the raw email message for debugging
-func (s *Sender) saveDebugEmail(emailID string, m *gomail.Message, req *models.EmailRequest) {
-	// Create debug directory
-	dateStr := time.Now().Format("2006-01-02")
-	dirPath := filepath.Join("data", dateStr, "debug")
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		fmt.Printf("Failed to create debug directory %s: %v\n", dirPath, err)
-		return
-	}
-
-	// Save message to file
-	filePath := filepath.Join(dirPath, fmt.Sprintf("%s_email.txt", emailID))
-	file, err := os.Create(filePath)
+// PruneDebugEmails removes the debug dumps of whole days older than before
+// and returns how many were removed
+func PruneDebugEmails(dataDir string, before time.Time) (int, error) {
+	days, err := filepath.Glob(filepath.Join(dataDir, "*", "debug"))
 	if err != nil {
-		fmt.Printf("Failed to create debug file %s: %v\n", filePath, err)
-		return
+		return 0, err
 	}
-	defer file.Close()
 
-	// Write message to file
-	if _, err := m.WriteTo(file); err != nil {
-		fmt.Printf("Failed to write debug email to %s: %v\n", filePath, err)
+	removed := 0
+	for _, dirPath := range days {
+		day := filepath.Base(filepath.Dir(dirPath))
+		start, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil || start.AddDate(0, 0, 1).After(before) {
+			continue
+		}
+
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return removed, fmt.Errorf("failed to read debug directory: %w", err)
+		}
+		if err := os.RemoveAll(dirPath); err != nil {
+			return removed, fmt.Errorf("failed to remove expired debug emails: %w", err)
+		}
+		removed += len(entries)
+
+		// Only succeeds once nothing else is left for the day
+		os.Remove(filepath.Dir(dirPath))
 	}
+	return removed, nil
 }
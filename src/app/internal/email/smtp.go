@@ -0,0 +1,242 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/metrics"
+	"github.com/hnrobert/smtogo/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/gomail.v2"
+)
+
+// dialTimeout limits how long connecting to a relay may take
+const dialTimeout = 10 * time.Second
+
+// sendMessage delivers the message through the identity's SMTP relay. The
+// dial, handshake and send phases are timed separately so that slow or
+// failing relays can be told apart from slow recipients, and each phase of
+// the session gets its own span under the one in ctx.
+func (s *Sender) sendMessage(ctx context.Context, identity *config.SenderIdentity, m *gomail.Message) error {
+	relay := identity.Relay
+	addr := relay.Address()
+	tlsConfig := &tls.Config{ServerName: relay.SMTPServer, RootCAs: s.rootCAs}
+	metrics.SMTPSessionsInFlight.WithLabelValues(addr).Inc()
+	defer metrics.SMTPSessionsInFlight.WithLabelValues(addr).Dec()
+
+	start := time.Now()
+	_, span := tracing.Start(ctx, "smtp.dial", trace.WithAttributes(attribute.String("smtp.relay", addr)))
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	tracing.End(span, err)
+	metrics.ObservePhase(addr, metrics.PhaseDial, start, err)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	client, err := handshake(ctx, conn, identity, tlsConfig, usesImplicitTLS(relay))
+	metrics.ObservePhase(addr, metrics.PhaseHandshake, start, err)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer func() {
+		// QUIT only closes the connection when the server answers
+		if client.Quit() != nil {
+			client.Close()
+		}
+	}()
+
+	start = time.Now()
+	_, span = tracing.Start(ctx, "smtp.data")
+	err = gomail.Send(gomail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+		span.SetAttributes(attribute.Int("smtp.recipients", len(to)))
+		return transmit(client, from, to, msg)
+	}), m)
+	tracing.End(span, err)
+	metrics.ObservePhase(addr, metrics.PhaseSend, start, err)
+	return err
+}
+
+// CheckRelay opens a session with the identity's relay, as a delivery
+// would, and checks that it answers EHLO. With authenticate set, an
+// identity that uses a password also logs in. No message is sent.
+func CheckRelay(ctx context.Context, identity *config.SenderIdentity, authenticate bool, timeout time.Duration) error {
+	relay := identity.Relay
+	deadline := time.Now().Add(timeout)
+
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", relay.Address())
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+
+	checked := *identity
+	checked.UsePassword = identity.UsePassword && authenticate
+	client, err := handshake(ctx, conn, &checked, &tls.Config{ServerName: relay.SMTPServer}, usesImplicitTLS(relay))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	// NOOP sends EHLO first if the handshake has not needed it yet
+	if err := client.Noop(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// temporary reports whether a delivery that failed with err can be tried
+// again without risking a duplicate: the connection was never made, or the
+// relay answered with a 4xx reply and so did not accept the message
+func temporary(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 400 && reply.Code < 500
+}
+
+// usesImplicitTLS reports whether the relay expects TLS from the start.
+// Port 465 always does, as it did with gomail.
+func usesImplicitTLS(relay *config.Relay) bool {
+	return relay.UseSSL || relay.SMTPPort == 465
+}
+
+// handshake starts the SMTP session: implicit TLS or STARTTLS when the
+// server offers it, then authentication when the identity uses a password
+func handshake(ctx context.Context, conn net.Conn, identity *config.SenderIdentity, tlsConfig *tls.Config, implicitTLS bool) (*smtp.Client, error) {
+	if implicitTLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		_, span := tracing.Start(ctx, "smtp.tls", trace.WithAttributes(attribute.Bool("smtp.implicit_tls", true)))
+		err := tlsConn.HandshakeContext(ctx)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	client, err := smtp.NewClient(conn, identity.Relay.SMTPServer)
+	if err != nil {
+		return nil, err
+	}
+
+	if !implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			_, span := tracing.Start(ctx, "smtp.tls", trace.WithAttributes(attribute.Bool("smtp.implicit_tls", false)))
+			err := client.StartTLS(tlsConfig)
+			tracing.End(span, err)
+			if err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if identity.UsePassword {
+		if ok, mechanisms := client.Extension("AUTH"); ok {
+			_, span := tracing.Start(ctx, "smtp.auth")
+			err := client.Auth(chooseAuth(mechanisms, identity))
+			tracing.End(span, err)
+			if err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+	return client, nil
+}
+
+// transmit sends one message over an established session
+func transmit(client *smtp.Client, from string, to []string, msg io.WriterTo) error {
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// chooseAuth picks the authentication mechanism the same way gomail does:
+// CRAM-MD5 when offered, LOGIN when PLAIN is not, and PLAIN otherwise
+func chooseAuth(mechanisms string, identity *config.SenderIdentity) smtp.Auth {
+	host := identity.Relay.SMTPServer
+	switch {
+	case strings.Contains(mechanisms, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(identity.Email, identity.Password)
+	case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+		return &loginAuth{username: identity.Email, password: identity.Password, host: host}
+	default:
+		return smtp.PlainAuth("", identity.Email, identity.Password, host)
+	}
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which net/smtp
+// does not provide
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// Start begins the exchange, refusing to send credentials in the clear
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		advertised := false
+		for _, mechanism := range server.Auth {
+			if mechanism == "LOGIN" {
+				advertised = true
+				break
+			}
+		}
+		if !advertised {
+			return "", nil, errors.New("unencrypted connection")
+		}
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+// Next answers the server's username and password prompts
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
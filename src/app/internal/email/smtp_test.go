@@ -0,0 +1,346 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/metrics"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/gomail.v2"
+)
+
+// fakeSMTP accepts one session on a local port and records the commands
+// and message it receives. With tlsConfig set, it offers STARTTLS and
+// AUTH PLAIN.
+func fakeSMTP(t *testing.T, tlsConfig *tls.Config) (*config.Relay, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { conn.Close() }()
+
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO") && tlsConfig != nil:
+				reply("250-fake\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 fake")
+			case line == "STARTTLS":
+				reply("220 ready")
+				conn = tls.Server(conn, tlsConfig)
+				r = bufio.NewReader(conn)
+			case strings.HasPrefix(line, "AUTH"):
+				reply("235 authenticated")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		received <- lines
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	relay := &config.Relay{SMTPServer: host}
+	relay.SMTPPort, _ = net.LookupPort("tcp", port)
+	return relay, received
+}
+
+func TestSendMessage(t *testing.T) {
+	relay, received := fakeSMTP(t, nil)
+	identity := &config.SenderIdentity{ID: "default", Email: "noreply@example.com", Relay: relay}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", identity.Email)
+	m.SetHeader("To", "user@example.com")
+	m.SetHeader("Subject", "Hello")
+	m.SetBody("text/plain", "Hi there")
+
+	before := testutil.CollectAndCount(metrics.SMTPPhaseDuration)
+	assert.NoError(t, (&Sender{}).sendMessage(context.Background(), identity, m))
+
+	lines := strings.Join(<-received, "\n")
+	assert.Contains(t, lines, "MAIL FROM:<noreply@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<user@example.com>")
+	assert.Contains(t, lines, "Subject: Hello")
+	assert.Contains(t, lines, "Hi there")
+	assert.True(t, strings.HasSuffix(lines, "QUIT"))
+
+	// Test every phase was timed for the relay, and the session is no
+	// longer counted as in flight
+	assert.Equal(t, before+3, testutil.CollectAndCount(metrics.SMTPPhaseDuration))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.SMTPSessionsInFlight.WithLabelValues(relay.Address())))
+}
+
+func TestSendMessageDialError(t *testing.T) {
+	relay := &config.Relay{SMTPServer: "127.0.0.1", SMTPPort: 1}
+	identity := &config.SenderIdentity{ID: "default", Email: "noreply@example.com", Relay: relay}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", identity.Email)
+	m.SetHeader("To", "user@example.com")
+
+	assert.Error(t, (&Sender{}).sendMessage(context.Background(), identity, m))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SMTPErrors.WithLabelValues(relay.Address(), metrics.PhaseDial)))
+}
+
+func TestSendEmailRetries(t *testing.T) {
+	// The relay is always busy, so each session ends with its greeting
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	var sessions atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sessions.Add(1)
+			conn.Write([]byte("421 4.3.2 busy\r\n"))
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	cfg := &config.Config{
+		SMTPServer:  host,
+		SenderEmail: "noreply@example.com",
+		DataDir:     t.TempDir(),
+		Delivery:    config.Delivery{MaxRetries: 1, RetryDelay: 1},
+	}
+	cfg.SMTPPort, _ = net.LookupPort("tcp", port)
+	relay := net.JoinHostPort(host, port)
+
+	req := &models.EmailRequest{RecipientEmail: "user@example.com", Subject: "Hello", Body: "Hi", BodyType: "plain"}
+	sender := NewSender(cfg, store.OpenFileStore(cfg.DataDir, nil), nil)
+	assert.Error(t, sender.SendEmail(context.Background(), req, models.EmailResult{EmailID: "e1"}, nil))
+
+	// Test the delivery was tried once more and then counted as failed
+	assert.Equal(t, int32(2), sessions.Load())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.EmailsRetried.WithLabelValues(relay, "default")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.EmailsFailed.WithLabelValues(relay, "default")))
+}
+
+func TestTemporary(t *testing.T) {
+	_, dialErr := net.Dial("tcp", "127.0.0.1:1")
+	assert.True(t, temporary(dialErr))
+	assert.True(t, temporary(&textproto.Error{Code: 451, Msg: "try again later"}))
+	assert.False(t, temporary(&textproto.Error{Code: 550, Msg: "no such user"}))
+	assert.False(t, temporary(io.ErrUnexpectedEOF))
+}
+
+// recordSpans installs a tracer provider that keeps finished spans in
+// memory for the duration of the test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// testCertificate returns a server TLS config for 127.0.0.1 and a pool
+// that trusts it
+func testCertificate(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake relay"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+func TestSendEmailSpans(t *testing.T) {
+	exporter := recordSpans(t)
+	serverTLS, rootCAs := testCertificate(t)
+	relay, received := fakeSMTP(t, serverTLS)
+	cfg := &config.Config{
+		SMTPServer:     relay.SMTPServer,
+		SMTPPort:       relay.SMTPPort,
+		SenderEmail:    "noreply@example.com",
+		SenderPassword: "secret",
+		UsePassword:    true,
+		DataDir:        t.TempDir(),
+	}
+
+	// The delivery continues the trace of the request that queued it
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := &models.EmailRequest{RecipientEmail: "user@example.com", Subject: "Hello", Body: "Hi", BodyType: "plain"}
+	result := models.EmailResult{EmailID: "e1", TraceParent: traceParent}
+	results := store.OpenFileStore(cfg.DataDir, nil)
+	sender := NewSender(cfg, results, nil)
+	sender.rootCAs = rootCAs
+	assert.NoError(t, sender.SendEmail(context.Background(), req, result, nil))
+	lines := <-received
+	assert.Contains(t, lines, "STARTTLS")
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range exporter.GetSpans().Snapshots() {
+		spans[span.Name()] = span
+	}
+	deliver, ok := spans["deliver email"]
+	if assert.True(t, ok) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", deliver.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", deliver.Parent().SpanID().String())
+	}
+	for _, name := range []string{"smtp.dial", "smtp.tls", "smtp.auth", "smtp.data"} {
+		if span, ok := spans[name]; assert.True(t, ok, name) {
+			assert.Equal(t, deliver.SpanContext().SpanID(), span.Parent().SpanID(), name)
+		}
+	}
+
+	// Test the stored result keeps the trace context
+	stored, err := results.Get(context.Background(), "e1")
+	assert.NoError(t, err)
+	assert.Equal(t, traceParent, stored.TraceParent)
+}
+
+func TestSendMessageSpanError(t *testing.T) {
+	exporter := recordSpans(t)
+	relay := &config.Relay{SMTPServer: "127.0.0.1", SMTPPort: 1}
+	identity := &config.SenderIdentity{ID: "default", Email: "noreply@example.com", Relay: relay}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", identity.Email)
+	m.SetHeader("To", "user@example.com")
+
+	assert.Error(t, (&Sender{}).sendMessage(context.Background(), identity, m))
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "smtp.dial", spans[0].Name)
+		assert.Equal(t, "Error", spans[0].Status.Code.String())
+		assert.NotEmpty(t, spans[0].Events)
+	}
+}
+
+func TestCheckRelay(t *testing.T) {
+	relay, received := fakeSMTP(t, nil)
+	identity := &config.SenderIdentity{ID: "default", Email: "noreply@example.com", Relay: relay}
+
+	assert.NoError(t, CheckRelay(context.Background(), identity, true, time.Second))
+
+	// Test the session is opened and closed without sending a message
+	lines := <-received
+	assert.True(t, strings.HasPrefix(lines[0], "EHLO"))
+	assert.Contains(t, lines, "NOOP")
+	assert.Equal(t, "QUIT", lines[len(lines)-1])
+	assert.NotContains(t, strings.Join(lines, "\n"), "MAIL FROM")
+
+	relay = &config.Relay{SMTPServer: "127.0.0.1", SMTPPort: 1}
+	identity.Relay = relay
+	assert.Error(t, CheckRelay(context.Background(), identity, false, time.Second))
+
+	// Test a relay that never answers fails the check after the timeout
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	identity.Relay = &config.Relay{SMTPServer: host}
+	identity.Relay.SMTPPort, _ = net.LookupPort("tcp", port)
+	assert.ErrorIs(t, CheckRelay(context.Background(), identity, false, 50*time.Millisecond), os.ErrDeadlineExceeded)
+}
+
+func TestSaveDebugEmailEncrypted(t *testing.T) {
+	relay, received := fakeSMTP(t, nil)
+	cfg := &config.Config{
+		SMTPServer:  relay.SMTPServer,
+		SMTPPort:    relay.SMTPPort,
+		SenderEmail: "noreply@example.com",
+		DataDir:     t.TempDir(),
+	}
+	keys, err := encryption.New(config.Encryption{
+		Keys:       []config.EncryptionKey{{ID: "k1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}},
+		PrimaryKey: "k1",
+	})
+	assert.NoError(t, err)
+
+	req := &models.EmailRequest{RecipientEmail: "user@example.com", Subject: "Private", Body: "Hi", BodyType: "plain", Debug: true}
+	sender := NewSender(cfg, store.OpenFileStore(cfg.DataDir, keys), keys)
+	assert.NoError(t, sender.SendEmail(context.Background(), req, models.EmailResult{EmailID: "e1"}, nil))
+	<-received
+
+	paths, _ := filepath.Glob(filepath.Join(cfg.DataDir, "*", "debug", "e1_email.txt"))
+	if assert.Len(t, paths, 1) {
+		data, err := os.ReadFile(paths[0])
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "Private")
+		plaintext, err := keys.Open(data)
+		assert.NoError(t, err)
+		assert.Contains(t, string(plaintext), "Subject: Private")
+	}
+}
@@ -0,0 +1,228 @@
+// Package encryption seals records written to the data directory with
+// envelope encryption. Each record is encrypted with its own random data
+// key using AES-GCM, and the data key is encrypted with a configured key
+// whose ID is stored in the record header, so keys can be rotated without
+// rewriting old records.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// magic starts every sealed record. Anything else is read as plaintext,
+// so records written before encryption was enabled stay readable.
+var magic = []byte("SMTGENC1")
+
+// dataKeySize is the size of the per-record AES-256 key
+const dataKeySize = 32
+
+// ErrNoKeys is returned when reading a sealed record without a keyring
+var ErrNoKeys = errors.New("record is encrypted but no encryption keys are configured")
+
+// Keyring seals records with its primary key and opens records sealed
+// with any of its keys. A nil Keyring leaves records in plaintext.
+type Keyring struct {
+	primary string
+	keys    map[string]*key
+}
+
+// key is one key-encryption key
+type key struct {
+	aead cipher.AEAD
+
+	// index keys the blind indexes of looked up values
+	index []byte
+}
+
+// New creates a keyring from the configured keys. It returns nil when
+// encryption is not enabled.
+func New(cfg config.Encryption) (*Keyring, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+
+	k := &Keyring{primary: cfg.PrimaryKey, keys: make(map[string]*key)}
+	for _, configured := range cfg.Keys {
+		secret, err := configured.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", configured.ID, err)
+		}
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", configured.ID, err)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("smtogo blind index"))
+		k.keys[configured.ID] = &key{aead: aead, index: mac.Sum(nil)}
+	}
+	if k.keys[k.primary] == nil {
+		return nil, fmt.Errorf("primary encryption key %q is not configured", k.primary)
+	}
+	return k, nil
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the primary key
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := sealHeader(k.primary)
+	out := append([]byte{}, header...)
+	out, err = seal(out, k.keys[k.primary].aead, dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return seal(out, dataAEAD, plaintext, header)
+}
+
+// Open decrypts a sealed record with the key named in its header. Records
+// that are not sealed are returned as they are.
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoKeys
+	}
+
+	id, rest, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	kek := k.keys[id]
+	if kek == nil {
+		return nil, fmt.Errorf("record is encrypted with unknown key %q", id)
+	}
+	header := data[:len(data)-len(rest)]
+
+	dataKey, rest, err := open(kek.aead, rest, dataKeySize+kek.aead.Overhead(), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with key %q: %w", id, err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, _, err := open(dataAEAD, rest, len(rest)-dataAEAD.NonceSize(), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record: %w", err)
+	}
+	return plaintext, nil
+}
+
+// IsSealed reports whether data is a sealed record
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// KeyID returns the ID of the key a sealed record was written with
+func KeyID(data []byte) (string, error) {
+	if !IsSealed(data) {
+		return "", errors.New("record is not encrypted")
+	}
+	id, _, err := parseHeader(data)
+	return id, err
+}
+
+// BlindIndex returns a value to store in place of a plaintext lookup value,
+// such as a recipient column: its HMAC under the primary key. A nil
+// keyring returns the value itself.
+func (k *Keyring) BlindIndex(value string) string {
+	if k == nil {
+		return value
+	}
+	return blindIndex(k.keys[k.primary], value)
+}
+
+// BlindIndexes returns every value a lookup of value should match: the
+// value itself, for records written without encryption, and its blind
+// index under each key
+func (k *Keyring) BlindIndexes(value string) []string {
+	indexes := []string{value}
+	if k == nil {
+		return indexes
+	}
+	for _, kek := range k.keys {
+		indexes = append(indexes, blindIndex(kek, value))
+	}
+	return indexes
+}
+
+// blindIndex returns the hex HMAC-SHA256 of value under kek's index key
+func blindIndex(kek *key, value string) string {
+	mac := hmac.New(sha256.New, kek.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealHeader returns the magic followed by the length-prefixed key ID. The
+// header is authenticated along with both ciphertexts.
+func sealHeader(id string) []byte {
+	header := append([]byte{}, magic...)
+	header = append(header, byte(len(id)))
+	return append(header, id...)
+}
+
+// parseHeader returns the key ID of a sealed record and what follows the
+// header
+func parseHeader(data []byte) (string, []byte, error) {
+	rest := data[len(magic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return "", nil, errors.New("encrypted record is truncated")
+	}
+	n := int(rest[0])
+	return string(rest[1 : 1+n]), rest[1+n:], nil
+}
+
+// seal appends a random nonce and the ciphertext of plaintext to out
+func seal(out []byte, aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, additional), nil
+}
+
+// open decrypts a nonce followed by size bytes of ciphertext at the start
+// of data and returns the plaintext and what follows
+func open(aead cipher.AEAD, data []byte, size int, additional []byte) ([]byte, []byte, error) {
+	n := aead.NonceSize()
+	if size < aead.Overhead() || len(data) < n+size {
+		return nil, nil, errors.New("encrypted record is truncated")
+	}
+	plaintext, err := aead.Open(nil, data[:n], data[n:n+size], additional)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, data[n+size:], nil
+}
+
+// newAEAD returns AES-GCM with the given key
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,106 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKey returns a base64 AES-256 key filled with b
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestSealOpen(t *testing.T) {
+	keys, err := New(config.Encryption{Keys: []config.EncryptionKey{{ID: "2024-01", Key: testKey(1)}}, PrimaryKey: "2024-01"})
+	assert.NoError(t, err)
+
+	plaintext := []byte(`{"recipient":"someone@example.com"}`)
+	sealed, err := keys.Seal(plaintext)
+	assert.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.NotContains(t, string(sealed), "someone")
+	id, err := KeyID(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01", id)
+
+	opened, err := keys.Open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Test every record gets its own data key and nonces
+	again, _ := keys.Seal(plaintext)
+	assert.NotEqual(t, sealed, again)
+
+	// Test plaintext records are read as they are
+	opened, err = keys.Open(plaintext)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Test tampering with the header or ciphertext is detected
+	for _, i := range []int{len(magic) + 1, len(sealed) - 1} {
+		tampered := append([]byte{}, sealed...)
+		tampered[i] ^= 1
+		_, err := keys.Open(tampered)
+		assert.Error(t, err, i)
+	}
+	_, err = keys.Open(sealed[:len(magic)+20])
+	assert.Error(t, err)
+}
+
+func TestNilKeyring(t *testing.T) {
+	keys, err := New(config.Encryption{})
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+
+	sealed, err := keys.Seal([]byte("plain"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("plain"), sealed)
+	assert.Equal(t, "a@example.com", keys.BlindIndex("a@example.com"))
+
+	other, _ := New(config.Encryption{Keys: []config.EncryptionKey{{ID: "k", Key: testKey(1)}}, PrimaryKey: "k"})
+	sealed, _ = other.Seal([]byte("secret"))
+	_, err = keys.Open(sealed)
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := config.EncryptionKey{ID: "old", Key: testKey(1)}
+	newKey := config.EncryptionKey{ID: "new", Key: testKey(2)}
+	before, err := New(config.Encryption{Keys: []config.EncryptionKey{oldKey}, PrimaryKey: "old"})
+	assert.NoError(t, err)
+	after, err := New(config.Encryption{Keys: []config.EncryptionKey{newKey, oldKey}, PrimaryKey: "new"})
+	assert.NoError(t, err)
+
+	// Test records written before the rotation can still be read
+	sealed, _ := before.Seal([]byte("record"))
+	opened, err := after.Open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("record"), opened)
+
+	sealed, _ = after.Seal([]byte("record"))
+	id, _ := KeyID(sealed)
+	assert.Equal(t, "new", id)
+	_, err = before.Open(sealed)
+	assert.ErrorContains(t, err, `unknown key "new"`)
+
+	// Test lookups match values indexed under either key or none
+	indexes := after.BlindIndexes("a@example.com")
+	assert.Len(t, indexes, 3)
+	assert.Contains(t, indexes, "a@example.com")
+	assert.Contains(t, indexes, before.BlindIndex("a@example.com"))
+	assert.Contains(t, indexes, after.BlindIndex("a@example.com"))
+	assert.NotEqual(t, before.BlindIndex("a@example.com"), after.BlindIndex("a@example.com"))
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	_, err := New(config.Encryption{Keys: []config.EncryptionKey{{ID: "k", Key: "short"}}, PrimaryKey: "k"})
+	assert.Error(t, err)
+
+	_, err = New(config.Encryption{Keys: []config.EncryptionKey{{ID: "k", Key: testKey(1)}}, PrimaryKey: "other"})
+	assert.ErrorContains(t, err, `primary encryption key "other"`)
+}
@@ -0,0 +1,143 @@
+// Package janitor removes records that have outlived their retention
+// period.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/archive"
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/email"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/metrics"
+	"github.com/hnrobert/smtogo/internal/store"
+)
+
+// Record types with their own retention period
+const (
+	TypeSuccess = "success"
+	TypeFailure = "failure"
+	TypeDebug   = "debug"
+	TypeBundle  = "bundle"
+)
+
+// Archived is the report entry counting results moved into bundles
+const Archived = "archived"
+
+// Report counts the records archived and removed by one run, by type
+type Report map[string]int
+
+// Janitor periodically archives old email results and removes expired
+// results, debug dumps and archive bundles
+type Janitor struct {
+	config  atomic.Pointer[config.Config]
+	results store.Store
+	keys    *encryption.Keyring
+}
+
+// New creates a janitor for the results in results. Bundles are encrypted
+// with keys unless it is nil.
+func New(cfg *config.Config, results store.Store, keys *encryption.Keyring) *Janitor {
+	j := &Janitor{results: results, keys: keys}
+	j.config.Store(cfg)
+	return j
+}
+
+// SetConfig replaces the configuration; the new retention periods and
+// interval apply from the next run
+func (j *Janitor) SetConfig(cfg *config.Config) {
+	j.config.Store(cfg)
+}
+
+// Run runs the janitor until ctx is done, first at startup and then every
+// retention.interval. Runs are skipped while nothing is archived and no
+// record type expires.
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		cfg := j.config.Load()
+		if cfg.IsRetentionEnabled() || cfg.Archive.Enabled {
+			report, err := j.RunOnce(ctx, time.Now())
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Janitor run failed", "error", err)
+			} else if total(report) > 0 {
+				slog.Info("Janitor archived and removed records", Archived, report[Archived],
+					TypeSuccess, report[TypeSuccess], TypeFailure, report[TypeFailure],
+					TypeDebug, report[TypeDebug], TypeBundle, report[TypeBundle])
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.Retention.JanitorInterval()):
+		}
+	}
+}
+
+// RunOnce archives the finished days due at now, if archiving is enabled,
+// and then removes the records that have expired. Every step is tried even
+// if another fails; the first error is returned.
+func (j *Janitor) RunOnce(ctx context.Context, now time.Time) (Report, error) {
+	cfg := j.config.Load()
+	report := Report{}
+	var firstErr error
+
+	if cfg.Archive.Enabled {
+		afterDays := cfg.Archive.AfterDays
+		if afterDays < 1 {
+			afterDays = 1
+		}
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		n, err := archive.Days(ctx, j.results, cfg.ArchiveDir(), j.keys, today.AddDate(0, 0, 1-afterDays))
+		report[Archived] = n
+		metrics.JanitorArchived.Add(float64(n))
+		firstErr = err
+	}
+	prune := func(recordType string, days int, remove func(before time.Time) (int, error)) {
+		if days <= 0 {
+			return
+		}
+		n, err := remove(now.AddDate(0, 0, -days))
+		report[recordType] += n
+		metrics.JanitorRemoved.WithLabelValues(recordType).Add(float64(n))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	prune(TypeSuccess, cfg.Retention.SuccessDays, func(before time.Time) (int, error) {
+		return j.results.Prune(ctx, "success", before)
+	})
+	prune(TypeFailure, cfg.Retention.FailureDays, func(before time.Time) (int, error) {
+		return j.results.Prune(ctx, "failure", before)
+	})
+	prune(TypeDebug, cfg.Retention.DebugDays, func(before time.Time) (int, error) {
+		return email.PruneDebugEmails(cfg.DataDir, before)
+	})
+	if cfg.Archive.Enabled {
+		prune(TypeBundle, cfg.Archive.KeepDays, func(before time.Time) (int, error) {
+			return archive.Prune(cfg.ArchiveDir(), before)
+		})
+	}
+
+	result := "success"
+	if firstErr != nil {
+		result = "error"
+	}
+	metrics.JanitorRuns.WithLabelValues(result).Inc()
+	metrics.JanitorLastRun.SetToCurrentTime()
+	return report, firstErr
+}
+
+// total sums the removed records
+func total(report Report) int {
+	n := 0
+	for _, count := range report {
+		n += count
+	}
+	return n
+}
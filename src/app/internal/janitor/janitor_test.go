@@ -0,0 +1,131 @@
+package janitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/metrics"
+	"github.com/hnrobert/smtogo/internal/models"
+	"github.com/hnrobert/smtogo/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	results := store.OpenFileStore(dataDir, nil)
+	now := time.Now()
+
+	save := func(id, status string, age int) {
+		t.Helper()
+		assert.NoError(t, results.Save(ctx, &models.EmailResult{
+			EmailID: id, Status: status, Timestamp: now.AddDate(0, 0, -age).Format(time.RFC3339),
+		}))
+	}
+	save("ok-40", "success", 40)
+	save("ok-5", "success", 5)
+	save("failed-40", "failure", 40)
+	save("failed-5", "failure", 5)
+
+	// Debug dumps 40 and 2 days old
+	for _, age := range []int{40, 2} {
+		dir := filepath.Join(dataDir, now.AddDate(0, 0, -age).Format("2006-01-02"), "debug")
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "x_email.txt"), []byte("raw"), 0644))
+	}
+
+	cfg := &config.Config{
+		DataDir:   dataDir,
+		Retention: config.Retention{SuccessDays: 30, FailureDays: 0, DebugDays: 1},
+	}
+	before := testutil.ToFloat64(metrics.JanitorRemoved.WithLabelValues(TypeDebug))
+
+	report, err := New(cfg, results, nil).RunOnce(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, Report{TypeSuccess: 1, TypeDebug: 2}, report)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.JanitorRemoved.WithLabelValues(TypeDebug)))
+
+	// Test failures are kept forever with a retention of 0
+	for id, exists := range map[string]bool{"ok-40": false, "ok-5": true, "failed-40": true, "failed-5": true} {
+		_, err := results.Get(ctx, id)
+		assert.Equal(t, exists, err == nil, id)
+	}
+
+	// Test the emptied day is removed along with its debug dumps
+	_, err = os.Stat(filepath.Join(dataDir, now.AddDate(0, 0, -2).Format("2006-01-02")))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunOnceArchives(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	results, err := store.OpenSQLiteStore(filepath.Join(dataDir, "emails.db"), nil)
+	assert.NoError(t, err)
+	defer results.Close()
+	now := time.Now()
+
+	for id, age := range map[string]int{"old": 10, "recent": 2, "today": 0} {
+		assert.NoError(t, results.Save(ctx, &models.EmailResult{
+			EmailID: id, Status: "success", Timestamp: now.AddDate(0, 0, -age).Format(time.RFC3339),
+		}))
+	}
+	// A bundle that has outlived keep_days
+	archiveDir := filepath.Join(dataDir, "archive")
+	assert.NoError(t, os.MkdirAll(archiveDir, 0755))
+	expired := now.AddDate(0, 0, -100).Format("2006-01-02") + ".jsonl.gz"
+	assert.NoError(t, os.WriteFile(filepath.Join(archiveDir, expired), nil, 0644))
+
+	cfg := &config.Config{
+		DataDir: dataDir,
+		Archive: config.Archive{Enabled: true, AfterDays: 3, KeepDays: 30},
+	}
+	before := testutil.ToFloat64(metrics.JanitorArchived)
+
+	report, err := New(cfg, results, nil).RunOnce(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Archived: 1, TypeBundle: 1}, report)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.JanitorArchived))
+
+	// Test only results older than after_days leave the store
+	for id, exists := range map[string]bool{"old": false, "recent": true, "today": true} {
+		_, err := results.Get(ctx, id)
+		assert.Equal(t, exists, err == nil, id)
+	}
+	_, err = os.Stat(filepath.Join(archiveDir, now.AddDate(0, 0, -10).Format("2006-01-02")+".jsonl.gz"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(archiveDir, expired))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	cfg := &config.Config{DataDir: t.TempDir(), Retention: config.Retention{SuccessDays: 1}}
+	j := New(cfg, store.OpenFileStore(cfg.DataDir, nil), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	before := testutil.ToFloat64(metrics.JanitorRuns.WithLabelValues("success"))
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	// Test the first run happens at startup
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.JanitorRuns.WithLabelValues("success")) == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.JanitorRuns.WithLabelValues("success")))
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not stop")
+	}
+}
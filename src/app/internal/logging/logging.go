@@ -0,0 +1,100 @@
+// Package logging sets up structured logging and carries request-scoped
+// loggers through contexts
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"api_key":             true,
+	"x-api-key":           true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"key":                 true,
+	"password":            true,
+	"pepper":              true,
+	"secret":              true,
+	"signature":           true,
+	"x-signature":         true,
+	"token":               true,
+}
+
+// sensitiveSuffixes mark attribute keys such as sender_password or
+// smtp_secret
+var sensitiveSuffixes = []string{"_password", "_secret", "_token", "_pepper", "_api_key"}
+
+// secretPrefix is the prefix of generated API key secrets, redacted
+// wherever it appears as a value
+const secretPrefix = "smtogo_"
+
+// New returns a logger writing to w with the configured level and format.
+// Sensitive attributes are redacted.
+func New(cfg config.Logging, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.SlogLevel(),
+		ReplaceAttr: redact,
+	}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Configure replaces the default logger with one writing to stderr
+func Configure(cfg config.Logging) {
+	slog.SetDefault(New(cfg, os.Stderr))
+}
+
+// redact replaces the values of sensitive attributes
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() == slog.KindString && strings.HasPrefix(a.Value.String(), secretPrefix) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// IsSensitive reports whether values under key must not be logged or
+// stored, such as passwords, API keys and bearer tokens
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
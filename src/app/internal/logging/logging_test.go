@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(config.Logging{Level: "info", Format: "json"}, &buf)
+
+	logger.Info("connecting",
+		"relay", "smtp.example.com:587",
+		"sender_password", "hunter2",
+		"X-API-Key", "abc",
+		"token", "eyJ...",
+		"Proxy-Authorization", "Basic dXNlcjpwYXNz",
+		"Set-Cookie", "session=abc",
+		"detail", "smtogo_abcdef",
+	)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "smtp.example.com:587", line["relay"])
+	assert.Equal(t, Redacted, line["sender_password"])
+	assert.Equal(t, Redacted, line["X-API-Key"])
+	assert.Equal(t, Redacted, line["token"])
+	assert.Equal(t, Redacted, line["Proxy-Authorization"])
+	assert.Equal(t, Redacted, line["Set-Cookie"])
+	assert.Equal(t, Redacted, line["detail"])
+	assert.NotContains(t, buf.String(), "hunter2")
+}
+
+func TestNewLevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(config.Logging{Level: "warn", Format: "text"}, &buf)
+
+	logger.Info("hidden")
+	logger.Warn("shown", "email_id", "123")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=WARN msg=shown email_id=123")
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, slog.Default(), FromContext(context.Background()))
+
+	logger := slog.Default().With("request_id", "abc")
+	assert.Equal(t, logger, FromContext(WithLogger(context.Background(), logger)))
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hnrobert/smtogo/internal/config"
+)
+
+// maskSuffix replaces the hidden part of a masked value
+const maskSuffix = "***"
+
+// subjectMaskLength is how many characters of a masked subject are kept
+const subjectMaskLength = 3
+
+// RedactRecipient returns the recipient address as the policy allows it
+// to be stored or logged
+func RedactRecipient(policy config.Redaction, addr string) string {
+	switch policy.Recipient {
+	case config.RedactMask:
+		return MaskEmail(addr)
+	case config.RedactOmit:
+		return ""
+	}
+	return addr
+}
+
+// RedactSubject returns the subject as the policy allows it to be stored
+// or logged
+func RedactSubject(policy config.Redaction, subject string) string {
+	switch policy.Subject {
+	case config.RedactFull:
+		return subject
+	case config.RedactMask:
+		return maskText(subject, subjectMaskLength)
+	}
+	return ""
+}
+
+// RedactHeaders returns the request headers the policy allows to be
+// stored, keeping the first value of each. Credentials are always left out.
+func RedactHeaders(policy config.Redaction, header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for key, values := range header {
+		if len(values) == 0 || IsSensitive(key) {
+			continue
+		}
+		if len(policy.AllowHeaders) > 0 && !containsFold(policy.AllowHeaders, key) {
+			continue
+		}
+		if containsFold(policy.DenyHeaders, key) {
+			continue
+		}
+		headers[key] = values[0]
+	}
+	return headers
+}
+
+// MessageAttrs returns the log attributes describing an email's recipient
+// and subject, leaving out those the policy omits
+func MessageAttrs(policy config.Redaction, recipient, subject string) []any {
+	var attrs []any
+	if r := RedactRecipient(policy, recipient); r != "" {
+		attrs = append(attrs, "recipient", r)
+	}
+	if s := RedactSubject(policy, subject); s != "" {
+		attrs = append(attrs, "subject", s)
+	}
+	return attrs
+}
+
+// ScrubRecipient replaces the recipient address wherever it appears in
+// text, such as an SMTP error, with its redacted form
+func ScrubRecipient(policy config.Redaction, text, recipient string) string {
+	if policy.Recipient == config.RedactFull || recipient == "" {
+		return text
+	}
+	replacement := RedactRecipient(policy, recipient)
+	if replacement == "" {
+		replacement = Redacted
+	}
+	re := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(recipient))
+	return re.ReplaceAllLiteralString(text, replacement)
+}
+
+// MaskEmail keeps the first character of an address's local part and its
+// domain, e.g. j***@example.com. Values that are already masked are
+// returned unchanged.
+func MaskEmail(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return maskText(addr, 1)
+	}
+	return maskText(addr[:at], 1) + addr[at:]
+}
+
+// maskText keeps the first keep characters of s, or none if s is not
+// longer than that
+func maskText(s string, keep int) string {
+	if s == "" {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= keep {
+		return maskSuffix
+	}
+	return string(runes[:keep]) + maskSuffix
+}
+
+// containsFold reports whether names contains name, ignoring case
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskEmail(t *testing.T) {
+	for addr, want := range map[string]string{
+		"john.doe@example.com": "j***@example.com",
+		"j@example.com":        "***@example.com",
+		"j***@example.com":     "j***@example.com",
+		"émile@example.fr":     "é***@example.fr",
+		"not-an-address":       "n***",
+		"":                     "",
+	} {
+		assert.Equal(t, want, MaskEmail(addr), addr)
+	}
+}
+
+func TestRedactRecipientAndSubject(t *testing.T) {
+	policy := config.Redaction{Recipient: config.RedactFull, Subject: config.RedactFull}
+	assert.Equal(t, "john@example.com", RedactRecipient(policy, "john@example.com"))
+	assert.Equal(t, "Your invoice", RedactSubject(policy, "Your invoice"))
+	assert.Equal(t, []any{"recipient", "john@example.com", "subject", "Your invoice"},
+		MessageAttrs(policy, "john@example.com", "Your invoice"))
+
+	policy = config.Redaction{Recipient: config.RedactMask, Subject: config.RedactMask}
+	assert.Equal(t, "j***@example.com", RedactRecipient(policy, "john@example.com"))
+	assert.Equal(t, "You***", RedactSubject(policy, "Your invoice"))
+	assert.Equal(t, "***", RedactSubject(policy, "Hi"))
+
+	// Test omitted values are left out of log attributes
+	policy = config.Redaction{Recipient: config.RedactOmit, Subject: config.RedactOmit}
+	assert.Empty(t, RedactRecipient(policy, "john@example.com"))
+	assert.Empty(t, RedactSubject(policy, "Your invoice"))
+	assert.Empty(t, MessageAttrs(policy, "john@example.com", "Your invoice"))
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("User-Agent", "billing/1.0")
+	header.Set("X-Forwarded-For", "203.0.113.7")
+	header.Set("Authorization", "Bearer abc")
+	header.Set("Cookie", "session=abc")
+	header.Set("X-API-Key", "secret")
+
+	// Test credentials are never stored
+	assert.Equal(t, map[string]string{"User-Agent": "billing/1.0", "X-Forwarded-For": "203.0.113.7"},
+		RedactHeaders(config.Redaction{}, header))
+
+	assert.Equal(t, map[string]string{"User-Agent": "billing/1.0"},
+		RedactHeaders(config.Redaction{DenyHeaders: []string{"x-forwarded-for"}}, header))
+	assert.Equal(t, map[string]string{"X-Forwarded-For": "203.0.113.7"},
+		RedactHeaders(config.Redaction{AllowHeaders: []string{"X-Forwarded-For", "Cookie"}}, header))
+}
+
+func TestScrubRecipient(t *testing.T) {
+	text := "550 5.1.1 <John@Example.com>: Recipient address rejected"
+	assert.Equal(t, text, ScrubRecipient(config.Redaction{Recipient: config.RedactFull}, text, "john@example.com"))
+	assert.Equal(t, "550 5.1.1 <j***@example.com>: Recipient address rejected",
+		ScrubRecipient(config.Redaction{Recipient: config.RedactMask}, text, "john@example.com"))
+	assert.Equal(t, "550 5.1.1 <[REDACTED]>: Recipient address rejected",
+		ScrubRecipient(config.Redaction{Recipient: config.RedactOmit}, text, "john@example.com"))
+}
@@ -0,0 +1,147 @@
+// Package metrics defines the Prometheus metrics exposed on /metrics
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// SMTP delivery phases
+const (
+	// PhaseDial is the TCP connection to the relay
+	PhaseDial = "dial"
+
+	// PhaseHandshake covers implicit TLS or STARTTLS, EHLO and AUTH
+	PhaseHandshake = "handshake"
+
+	// PhaseSend covers MAIL FROM, RCPT TO and DATA
+	PhaseSend = "send"
+)
+
+// Registry holds every smtogo metric along with the Go runtime and process
+// collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration observes API requests by route and status
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "smtogo_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// EmailsAccepted counts emails accepted by the send endpoint
+	EmailsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_emails_accepted_total",
+		Help: "Emails accepted for delivery by relay and sender identity.",
+	}, []string{"relay", "sender"})
+
+	// EmailsSent counts emails delivered to the relay
+	EmailsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_emails_sent_total",
+		Help: "Emails delivered to the SMTP relay by relay and sender identity.",
+	}, []string{"relay", "sender"})
+
+	// EmailsFailed counts emails that could not be delivered
+	EmailsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_emails_failed_total",
+		Help: "Emails that failed to deliver by relay and sender identity.",
+	}, []string{"relay", "sender"})
+
+	// EmailsRetried counts deliveries tried again after a temporary
+	// failure
+	EmailsRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_emails_retried_total",
+		Help: "Delivery retries after a temporary failure by relay and sender identity.",
+	}, []string{"relay", "sender"})
+
+	// SMTPPhaseDuration observes each phase of a delivery
+	SMTPPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "smtogo_smtp_phase_duration_seconds",
+		Help:    "Duration of SMTP dial, handshake and send phases by relay.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"relay", "phase"})
+
+	// SMTPErrors counts failed delivery phases, which indicates the health
+	// of the connection to each relay
+	SMTPErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_smtp_errors_total",
+		Help: "SMTP errors by relay and the phase that failed.",
+	}, []string{"relay", "phase"})
+
+	// SMTPSessionsInFlight is the number of open SMTP sessions, which
+	// shows how busy the delivery goroutines are with each relay
+	SMTPSessionsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "smtogo_smtp_sessions_in_flight",
+		Help: "SMTP sessions currently delivering an email by relay.",
+	}, []string{"relay"})
+
+	// QueueDepth is the number of accepted emails not yet delivered or
+	// failed
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smtogo_email_queue_depth",
+		Help: "Emails accepted but not yet delivered or failed.",
+	})
+
+	// JanitorRemoved counts expired records removed by the janitor
+	JanitorRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_janitor_removed_total",
+		Help: "Expired records removed by the janitor by type.",
+	}, []string{"type"})
+
+	// JanitorArchived counts results moved into archive bundles
+	JanitorArchived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "smtogo_janitor_archived_total",
+		Help: "Email results moved into archive bundles by the janitor.",
+	})
+
+	// JanitorRuns counts janitor runs by outcome
+	JanitorRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smtogo_janitor_runs_total",
+		Help: "Janitor runs by result.",
+	}, []string{"result"})
+
+	// JanitorLastRun is when the janitor last finished
+	JanitorLastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smtogo_janitor_last_run_timestamp_seconds",
+		Help: "Unix time the janitor last finished a run.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		EmailsAccepted,
+		EmailsSent,
+		EmailsFailed,
+		EmailsRetried,
+		SMTPPhaseDuration,
+		SMTPErrors,
+		SMTPSessionsInFlight,
+		QueueDepth,
+		JanitorRemoved,
+		JanitorArchived,
+		JanitorRuns,
+		JanitorLastRun,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObservePhase records the duration of an SMTP phase and counts it as an
+// error if it failed
+func ObservePhase(relay, phase string, start time.Time, err error) {
+	SMTPPhaseDuration.WithLabelValues(relay, phase).Observe(time.Since(start).Seconds())
+	if err != nil {
+		SMTPErrors.WithLabelValues(relay, phase).Inc()
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // EmailRequest represents an email sending request
 type EmailRequest struct {
 	RecipientEmail string `json:"recipient_email" form:"recipient_email" binding:"required,email"`
@@ -7,17 +9,30 @@ type EmailRequest struct {
 	Body           string `json:"body" form:"body" binding:"required"`
 	BodyType       string `json:"body_type" form:"body_type"`
 	Debug          bool   `json:"debug" form:"debug"`
+
+	// Sender identity, chosen by address or ID (both optional)
+	From     string `json:"from" form:"from"`
+	SenderID string `json:"sender_id" form:"sender_id"`
 }
 
 // EmailResult represents the result of an email sending operation
 type EmailResult struct {
 	EmailID       string            `json:"email_id"`
+	Recipient     string            `json:"recipient,omitempty"`
+	Subject       string            `json:"subject,omitempty"`
 	Status        string            `json:"status"`
 	Detail        string            `json:"detail"`
 	Timestamp     string            `json:"timestamp"`
 	ClientIP      string            `json:"client_ip"`
 	Headers       map[string]string `json:"headers"`
 	MessageLength int               `json:"message_length"`
+	SenderID      string            `json:"sender_id"`
+	APIKeyName    string            `json:"api_key_name"`
+	RequestID     string            `json:"request_id,omitempty"`
+
+	// TraceParent is the W3C trace context of the request that queued the
+	// email, which the delivery span continues
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 // APIResponse represents a standard API response
@@ -26,3 +41,38 @@ type APIResponse struct {
 	EmailID string `json:"email_id,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
+
+// APIKeyRequest represents a request to create a managed API key
+type APIKeyRequest struct {
+	Name           string     `json:"name" binding:"required"`
+	Scopes         []string   `json:"scopes" binding:"required"`
+	AllowedSenders []string   `json:"allowed_senders"`
+	AllowedIPs     []string   `json:"allowed_ips"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+
+	// Overrides of the rate_limit defaults (zero keeps the default)
+	RateLimitPerMinute int `json:"rate_limit_per_minute"`
+	DailyQuota         int `json:"daily_quota"`
+	MonthlyQuota       int `json:"monthly_quota"`
+}
+
+// APIKeyInfo describes an API key without revealing its secret. Key is
+// only set in the response that creates or rotates the key.
+type APIKeyInfo struct {
+	ID             string     `json:"id,omitempty"`
+	Name           string     `json:"name"`
+	Source         string     `json:"source"`
+	Key            string     `json:"key,omitempty"`
+	Hint           string     `json:"hint,omitempty"`
+	Scopes         []string   `json:"scopes"`
+	AllowedSenders []string   `json:"allowed_senders"`
+	AllowedIPs     []string   `json:"allowed_ips,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	RotatedAt      *time.Time `json:"rotated_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+
+	RateLimitPerMinute int `json:"rate_limit_per_minute,omitempty"`
+	DailyQuota         int `json:"daily_quota,omitempty"`
+	MonthlyQuota       int `json:"monthly_quota,omitempty"`
+}
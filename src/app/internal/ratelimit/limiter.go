@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Result describes the state of a limit after a request was counted
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the current window ends
+	Reset time.Duration
+}
+
+// Limiter counts requests per key in fixed windows
+type Limiter struct {
+	mu      sync.Mutex
+	period  time.Duration
+	windows map[string]*window
+}
+
+// window is the request count of one key in the current period
+type window struct {
+	start time.Time
+	count int
+}
+
+// NewLimiter creates a limiter with windows of the given length
+func NewLimiter(period time.Duration) *Limiter {
+	return &Limiter{
+		period:  period,
+		windows: make(map[string]*window),
+	}
+}
+
+// Allow counts a request for key and reports whether it is within limit.
+// Rejected requests are not counted.
+func (l *Limiter) Allow(key string, limit int, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.period {
+		// Drop expired windows now and then so the map does not grow
+		// with every key ever seen
+		if !ok && len(l.windows) >= 1024 {
+			l.sweep(now)
+		}
+		w = &window{start: now.Truncate(l.period)}
+		l.windows[key] = w
+	}
+
+	result := Result{
+		Limit: limit,
+		Reset: w.start.Add(l.period).Sub(now),
+	}
+	if w.count >= limit {
+		return result
+	}
+
+	w.count++
+	result.Allowed = true
+	result.Remaining = limit - w.count
+	return result
+}
+
+// sweep removes windows that have ended. The caller must hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.period {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FlushInterval is how often Run writes changed counts to disk
+const FlushInterval = 10 * time.Second
+
+// Usage reports how much of a key's daily and monthly quotas is used.
+// A limit of 0 means unlimited.
+type Usage struct {
+	Daily   Period `json:"daily"`
+	Monthly Period `json:"monthly"`
+}
+
+// Period is the usage of one quota period
+type Period struct {
+	Used    int       `json:"used"`
+	Limit   int       `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Exceeded reports whether another send would go over the limit
+func (p Period) Exceeded() bool {
+	return p.Limit > 0 && p.Used >= p.Limit
+}
+
+// counter holds the send counts of one key, keyed by UTC day and month
+type counter struct {
+	Day        string `json:"day"`
+	DayCount   int    `json:"day_count"`
+	Month      string `json:"month"`
+	MonthCount int    `json:"month_count"`
+}
+
+// QuotaTracker counts sends per key for daily and monthly quotas. Counts
+// are kept in memory and, when a path is given, written to it as JSON by
+// Flush so they survive restarts.
+type QuotaTracker struct {
+	mu       sync.Mutex
+	path     string
+	counters map[string]*counter
+
+	// dirty is set when counts changed since they were last written
+	dirty bool
+
+	// saveMu serializes writes of the file
+	saveMu sync.Mutex
+}
+
+// OpenQuotaTracker loads the counts at path, which need not exist yet. An
+// empty path keeps counts in memory only.
+func OpenQuotaTracker(path string) (*QuotaTracker, error) {
+	q := &QuotaTracker{path: path, counters: make(map[string]*counter)}
+	if path == "" {
+		return q, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &q.counters); err != nil {
+		return nil, fmt.Errorf("failed to parse quota usage %s: %w", path, err)
+	}
+	return q, nil
+}
+
+// Reserve counts a send for key if it fits both quotas. The returned usage
+// includes the send when it was allowed.
+func (q *QuotaTracker) Reserve(key string, daily, monthly int, now time.Time) (Usage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.counters[key]
+	if !ok {
+		c = &counter{}
+		q.counters[key] = c
+	}
+	rollOver(c, now)
+	usage := makeUsage(c, daily, monthly, now)
+	if usage.Daily.Exceeded() || usage.Monthly.Exceeded() {
+		return usage, false
+	}
+
+	c.DayCount++
+	c.MonthCount++
+	q.dirty = true
+	return makeUsage(c, daily, monthly, now), true
+}
+
+// Usage returns the current usage of key without counting a send
+func (q *QuotaTracker) Usage(key string, daily, monthly int, now time.Time) Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var c counter
+	if stored, ok := q.counters[key]; ok {
+		c = *stored
+	}
+	rollOver(&c, now)
+	return makeUsage(&c, daily, monthly, now)
+}
+
+// Run writes changed counts every interval until ctx is done, and once more
+// before returning
+func (q *QuotaTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := q.Flush(); err != nil {
+				slog.Error("Failed to save quota usage", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := q.Flush(); err != nil {
+				slog.Error("Failed to save quota usage", "error", err)
+			}
+		}
+	}
+}
+
+// Flush writes the counts to disk atomically if they changed since they
+// were last written. Sends are not blocked while the file is written.
+func (q *QuotaTracker) Flush() error {
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	if q.path == "" || !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(q.counters)
+	q.dirty = false
+	q.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal quota usage: %w", err)
+	}
+
+	if err := q.write(data); err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// rollOver resets the counts of periods that have passed
+func rollOver(c *counter, now time.Time) {
+	now = now.UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	if c.Day != day {
+		c.Day, c.DayCount = day, 0
+	}
+	if c.Month != month {
+		c.Month, c.MonthCount = month, 0
+	}
+}
+
+// write replaces the file with data. The caller must hold saveMu.
+func (q *QuotaTracker) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return fmt.Errorf("failed to create quota usage directory: %w", err)
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quota usage: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("failed to replace quota usage: %w", err)
+	}
+	return nil
+}
+
+// makeUsage builds the usage report of a counter
+func makeUsage(c *counter, daily, monthly int, now time.Time) Usage {
+	now = now.UTC()
+	return Usage{
+		Daily: Period{
+			Used:    c.DayCount,
+			Limit:   daily,
+			ResetAt: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		},
+		Monthly: Period{
+			Used:    c.MonthCount,
+			Limit:   monthly,
+			ResetAt: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(time.Minute)
+	now := time.Date(2026, 1, 1, 12, 0, 10, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		result := limiter.Allow("key", 2, now)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1-i, result.Remaining)
+	}
+
+	// Test the third request in the window is rejected
+	result := limiter.Allow("key", 2, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 50*time.Second, result.Reset)
+
+	// Test other keys and the next window are independent
+	assert.True(t, limiter.Allow("other", 2, now).Allowed)
+	assert.True(t, limiter.Allow("key", 2, now.Add(time.Minute)).Allowed)
+}
+
+func TestQuotaTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota_usage.json")
+	quota, err := OpenQuotaTracker(path)
+	assert.NoError(t, err)
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+
+	// Test the daily quota
+	_, ok := quota.Reserve("billing", 1, 3, now)
+	assert.True(t, ok)
+	usage, ok := quota.Reserve("billing", 1, 3, now)
+	assert.False(t, ok)
+	assert.Equal(t, 1, usage.Daily.Used)
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), usage.Daily.ResetAt)
+
+	// Test counts are written on flush and survive a restart
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, quota.Flush())
+	reopened, err := OpenQuotaTracker(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reopened.Usage("billing", 1, 3, now).Monthly.Used)
+
+	// Test the month rolls over
+	usage, ok = reopened.Reserve("billing", 1, 3, now.Add(2*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, 1, usage.Monthly.Used)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), usage.Monthly.ResetAt)
+
+	// Test a zero limit is unlimited
+	for i := 0; i < 5; i++ {
+		_, ok = reopened.Reserve("unlimited", 0, 0, now)
+		assert.True(t, ok)
+	}
+
+	// Test reading usage does not add keys
+	assert.Equal(t, 0, reopened.Usage("unknown", 1, 3, now).Daily.Used)
+	assert.NotContains(t, reopened.counters, "unknown")
+}
+
+func TestQuotaTrackerRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota_usage.json")
+	quota, err := OpenQuotaTracker(path)
+	assert.NoError(t, err)
+	quota.Reserve("billing", 0, 0, time.Now())
+
+	// Test the counts are written when the tracker stops
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		quota.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"billing"`)
+}
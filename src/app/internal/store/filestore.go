@@ -0,0 +1,272 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+)
+
+// dateLayout names the per-day directories of the filesystem store
+const dateLayout = "2006-01-02"
+
+// FileStore keeps each result as a JSON file under
+// <dir>/<date>/<success|failure>/<email_id>.json. Listing reads every file
+// of the days in range, so it suits small volumes. With a keyring the files
+// are sealed rather than JSON, under the same names.
+type FileStore struct {
+	dir  string
+	keys *encryption.Keyring
+}
+
+// OpenFileStore returns a store under dir, which is created on first save.
+// Results are encrypted with keys unless it is nil.
+func OpenFileStore(dir string, keys *encryption.Keyring) *FileStore {
+	return &FileStore{dir: dir, keys: keys}
+}
+
+// Save writes the result to the directory of its day and status
+func (s *FileStore) Save(ctx context.Context, result *models.EmailResult) error {
+	t := ResultTime(result)
+	if t.IsZero() {
+		t = time.Now()
+	}
+	dirPath := filepath.Join(s.dir, t.Format(dateLayout), statusDir(result.Status))
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create result directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal email result: %w", err)
+	}
+	if data, err = s.keys.Seal(data); err != nil {
+		return fmt.Errorf("failed to encrypt email result: %w", err)
+	}
+	filePath := filepath.Join(dirPath, result.EmailID+".json")
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save email result: %w", err)
+	}
+	return nil
+}
+
+// Get reads the result of an email from any day
+func (s *FileStore) Get(ctx context.Context, emailID string) (*models.EmailResult, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*", "*", emailID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return readResult(matches[0], s.keys)
+}
+
+// List reads the days in the query's range in order until the limit is
+// reached
+func (s *FileStore) List(ctx context.Context, q Query) ([]*models.EmailResult, error) {
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+
+	if !q.OldestFirst {
+		sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	}
+
+	results := []*models.EmailResult{}
+	for _, name := range days {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !dayInRange(name, q) {
+			continue
+		}
+
+		var day []*models.EmailResult
+		err := s.walkDay(name, func(result *models.EmailResult) error {
+			if q.Matches(result, ResultTime(result)) {
+				day = append(day, result)
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		sortByTime(day, q.OldestFirst)
+		results = append(results, day...)
+		if q.Limit > 0 && len(results) >= q.Limit {
+			return results[:q.Limit], nil
+		}
+	}
+	return results, nil
+}
+
+// Walk calls fn for every stored result, oldest day first
+func (s *FileStore) Walk(ctx context.Context, fn func(*models.EmailResult) error) error {
+	return s.walk(ctx, fn, nil)
+}
+
+// walk calls fn for every stored result, oldest day first. Files that
+// cannot be read are passed to bad, or stop the walk when bad is nil.
+func (s *FileStore) walk(ctx context.Context, fn func(*models.EmailResult) error, bad func(path string, err error)) error {
+	days, err := s.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.walkDay(day, fn, bad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes whole days whose results are all older than before, so
+// results are kept until the end of the day that expires them
+func (s *FileStore) Prune(ctx context.Context, status string, before time.Time) (int, error) {
+	days, err := s.days()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, day := range days {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		start, _ := time.ParseInLocation(dateLayout, day, time.Local)
+		if start.AddDate(0, 0, 1).After(before) {
+			break
+		}
+
+		dirPath := filepath.Join(s.dir, day, statusDir(status))
+		matches, err := filepath.Glob(filepath.Join(dirPath, "*.json"))
+		if err != nil {
+			return removed, err
+		}
+		if err := os.RemoveAll(dirPath); err != nil {
+			return removed, fmt.Errorf("failed to remove expired results: %w", err)
+		}
+		removed += len(matches)
+
+		// Only succeeds once nothing else is left for the day
+		os.Remove(filepath.Join(s.dir, day))
+	}
+	return removed, nil
+}
+
+// Close does nothing; the filesystem store holds no resources
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// days returns the names of the per-day directories in ascending order
+func (s *FileStore) days() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	var days []string
+	for _, entry := range entries {
+		if _, err := time.Parse(dateLayout, entry.Name()); err == nil && entry.IsDir() {
+			days = append(days, entry.Name())
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// walkDay calls fn for every result stored on day, passing files that
+// cannot be read to bad as walk does
+func (s *FileStore) walkDay(day string, fn func(*models.EmailResult) error, bad func(path string, err error)) error {
+	for _, dir := range []string{"success", "failure"} {
+		matches, err := filepath.Glob(filepath.Join(s.dir, day, dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			result, err := readResult(path, s.keys)
+			if err != nil && bad != nil {
+				bad(path, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statusDir returns the directory for results with status
+func statusDir(status string) string {
+	if status == "success" {
+		return "success"
+	}
+	return "failure"
+}
+
+// dayInRange reports whether a day directory may hold results in the
+// query's time range. Days are compared loosely, allowing for results saved
+// in another time zone.
+func dayInRange(day string, q Query) bool {
+	start, err := time.Parse(dateLayout, day)
+	if err != nil {
+		return false
+	}
+	if !q.Since.IsZero() && start.Add(48*time.Hour).Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && start.Add(-24*time.Hour).After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// readResult reads one result file, decrypting it if it is sealed
+func readResult(path string, keys *encryption.Keyring) (*models.EmailResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email result: %w", err)
+	}
+	if data, err = keys.Open(data); err != nil {
+		return nil, fmt.Errorf("failed to decrypt email result %s: %w", filepath.Base(path), err)
+	}
+	var result models.EmailResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse email result %s: %w", filepath.Base(path), err)
+	}
+	if result.EmailID == "" {
+		result.EmailID = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	return &result, nil
+}
+
+// sortByTime orders results by time, newest first unless oldestFirst is set
+func sortByTime(results []*models.EmailResult, oldestFirst bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if oldestFirst {
+			return ResultTime(results[i]).Before(ResultTime(results[j]))
+		}
+		return ResultTime(results[i]).After(ResultTime(results[j]))
+	})
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/hnrobert/smtogo/internal/models"
+)
+
+// importBatchSize is how many results are written per transaction
+const importBatchSize = 1000
+
+// SkippedFile is a result file that could not be imported
+type SkippedFile struct {
+	Path string
+	Err  error
+}
+
+// ImportFiles copies every result of the filesystem store under dir into
+// dst and returns how many were committed, along with the files that could
+// not be read or parsed, which are skipped. Results already in dst are
+// replaced, so an interrupted import can be run again. The files are left
+// in place. The files are read and the rows written with dst's keys.
+func ImportFiles(ctx context.Context, dir string, dst *SQLiteStore) (int, []SkippedFile, error) {
+	count, committed := 0, 0
+	var skipped []SkippedFile
+	var tx *sql.Tx
+	commit := func() error {
+		if tx == nil {
+			return nil
+		}
+		err := tx.Commit()
+		tx = nil
+		if err != nil {
+			return fmt.Errorf("failed to commit imported results: %w", err)
+		}
+		committed = count
+		return nil
+	}
+
+	err := OpenFileStore(dir, dst.keys).walk(ctx, func(result *models.EmailResult) error {
+		if tx == nil {
+			var err error
+			if tx, err = dst.db.BeginTx(ctx, nil); err != nil {
+				return fmt.Errorf("failed to start import: %w", err)
+			}
+		}
+		if err := save(ctx, tx, dst.keys, result); err != nil {
+			return err
+		}
+		count++
+		if count%importBatchSize == 0 {
+			return commit()
+		}
+		return nil
+	}, func(path string, err error) {
+		skipped = append(skipped, SkippedFile{Path: path, Err: err})
+	})
+	if err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return committed, skipped, err
+	}
+	err = commit()
+	return committed, skipped, err
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+
+	// Pure Go SQLite driver, so builds need no cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the results table. The full result is kept as JSON
+// in data, sealed when encrypting; the other columns are copies used for
+// lookups, with the recipient replaced by its blind index when encrypting.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS email_results (
+	email_id   TEXT PRIMARY KEY,
+	status     TEXT NOT NULL,
+	recipient  TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS email_results_status ON email_results (status, created_at);
+CREATE INDEX IF NOT EXISTS email_results_recipient ON email_results (recipient, created_at);
+CREATE INDEX IF NOT EXISTS email_results_created_at ON email_results (created_at);
+`
+
+// SQLiteStore keeps results in an SQLite database indexed by status,
+// recipient and time
+type SQLiteStore struct {
+	db   *sql.DB
+	keys *encryption.Keyring
+}
+
+// OpenSQLiteStore opens or creates the database at path. Results are
+// encrypted with keys unless it is nil.
+func OpenSQLiteStore(path string, keys *encryption.Keyring) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result database %s: %w", path, err)
+	}
+	// SQLite allows one writer; a single connection avoids lock errors
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create result database %s: %w", path, err)
+	}
+	return &SQLiteStore{db: db, keys: keys}, nil
+}
+
+// execer runs statements on the database or within a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Save inserts or replaces the result
+func (s *SQLiteStore) Save(ctx context.Context, result *models.EmailResult) error {
+	return save(ctx, s.db, s.keys, result)
+}
+
+// save inserts or replaces the result using exec, encrypted with keys
+func save(ctx context.Context, exec execer, keys *encryption.Keyring, result *models.EmailResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal email result: %w", err)
+	}
+	// Plaintext stays TEXT, readable with the sqlite3 shell; sealed results
+	// are stored as BLOBs
+	var value interface{} = string(data)
+	if keys != nil {
+		if value, err = keys.Seal(data); err != nil {
+			return fmt.Errorf("failed to encrypt email result: %w", err)
+		}
+	}
+	t := ResultTime(result)
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	_, err = exec.ExecContext(ctx, `
+		INSERT INTO email_results (email_id, status, recipient, created_at, data)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (email_id) DO UPDATE SET
+			status = excluded.status,
+			recipient = excluded.recipient,
+			created_at = excluded.created_at,
+			data = excluded.data`,
+		result.EmailID, result.Status, keys.BlindIndex(strings.ToLower(result.Recipient)), t.Unix(), value)
+	if err != nil {
+		return fmt.Errorf("failed to save email result: %w", err)
+	}
+	return nil
+}
+
+// Get returns the result of an email
+func (s *SQLiteStore) Get(ctx context.Context, emailID string) (*models.EmailResult, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM email_results WHERE email_id = ?`, emailID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email result: %w", err)
+	}
+	return s.decodeResult(data)
+}
+
+// List returns the matching results using the indexes
+func (s *SQLiteStore) List(ctx context.Context, q Query) ([]*models.EmailResult, error) {
+	var where []string
+	var args []interface{}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.Recipient != "" {
+		// Rows may be written in plaintext or under any of the keys
+		indexes := s.keys.BlindIndexes(strings.ToLower(q.Recipient))
+		where = append(where, "recipient IN (?"+strings.Repeat(", ?", len(indexes)-1)+")")
+		for _, index := range indexes {
+			args = append(args, index)
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Until.Unix())
+	}
+
+	query := `SELECT data FROM email_results`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if q.OldestFirst {
+		query += ` ORDER BY created_at, email_id`
+	} else {
+		query += ` ORDER BY created_at DESC, email_id`
+	}
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query email results: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*models.EmailResult{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to query email results: %w", err)
+		}
+		result, err := s.decodeResult(data)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query email results: %w", err)
+	}
+	return results, nil
+}
+
+// Prune deletes the expired results using the status index
+func (s *SQLiteStore) Prune(ctx context.Context, status string, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM email_results WHERE status = ? AND created_at < ?`, status, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune email results: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// decodeResult parses a stored result, decrypting it if it is sealed
+func (s *SQLiteStore) decodeResult(data []byte) (*models.EmailResult, error) {
+	data, err := s.keys.Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt email result: %w", err)
+	}
+	var result models.EmailResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse email result: %w", err)
+	}
+	return &result, nil
+}
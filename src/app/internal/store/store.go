@@ -0,0 +1,92 @@
+// Package store keeps the results of sent emails.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+)
+
+// ErrNotFound is returned when no result is stored for an email
+var ErrNotFound = errors.New("email result not found")
+
+// Store keeps email results. Implementations are safe for concurrent use.
+type Store interface {
+	// Save stores a result, replacing any earlier one for the same email
+	Save(ctx context.Context, result *models.EmailResult) error
+
+	// Get returns the result of an email, or ErrNotFound
+	Get(ctx context.Context, emailID string) (*models.EmailResult, error)
+
+	// List returns the results matching q, newest first unless
+	// q.OldestFirst is set
+	List(ctx context.Context, q Query) ([]*models.EmailResult, error)
+
+	// Prune removes the results with the given status recorded before
+	// before and returns how many were removed
+	Prune(ctx context.Context, status string, before time.Time) (int, error)
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// Query selects results. Zero fields match every result.
+type Query struct {
+	Status    string
+	Recipient string
+
+	// Since is inclusive and Until exclusive
+	Since time.Time
+	Until time.Time
+
+	// Limit caps the number of results; 0 means no limit
+	Limit int
+
+	// OldestFirst reverses the order of the results
+	OldestFirst bool
+}
+
+// Open opens the backend selected by the configuration. Results are
+// encrypted with keys unless it is nil.
+func Open(cfg *config.Config, keys *encryption.Keyring) (Store, error) {
+	switch cfg.Storage.Backend {
+	case "", config.StorageFilesystem:
+		return OpenFileStore(cfg.DataDir, keys), nil
+	case config.StorageSQLite:
+		return OpenSQLiteStore(cfg.ResultDBPath(), keys)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+}
+
+// ResultTime returns when a result was recorded, or the zero time if its
+// timestamp cannot be parsed
+func ResultTime(result *models.EmailResult) time.Time {
+	t, err := time.Parse(time.RFC3339, result.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Matches reports whether a result recorded at t is selected by q
+func (q *Query) Matches(result *models.EmailResult, t time.Time) bool {
+	if q.Status != "" && result.Status != q.Status {
+		return false
+	}
+	if q.Recipient != "" && !strings.EqualFold(result.Recipient, q.Recipient) {
+		return false
+	}
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
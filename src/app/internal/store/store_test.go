@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/smtogo/internal/config"
+	"github.com/hnrobert/smtogo/internal/encryption"
+	"github.com/hnrobert/smtogo/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stores returns each backend, empty, for the test, with and without
+// encryption
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	db, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "db", "emails.db"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	encryptedDB, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "db", "emails.db"), testKeyring(t, "k1"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { encryptedDB.Close() })
+	return map[string]Store{
+		"filesystem":           OpenFileStore(t.TempDir(), nil),
+		"sqlite":               db,
+		"encrypted filesystem": OpenFileStore(t.TempDir(), testKeyring(t, "k1")),
+		"encrypted sqlite":     encryptedDB,
+	}
+}
+
+// testKeyring returns a keyring of two fixed keys, k1 and k2, writing with
+// primary
+func testKeyring(t *testing.T, primary string) *encryption.Keyring {
+	t.Helper()
+	keys, err := encryption.New(config.Encryption{
+		Keys: []config.EncryptionKey{
+			{ID: "k1", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+			{ID: "k2", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))},
+		},
+		PrimaryKey: primary,
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+// result builds a result recorded at t
+func result(id, status, recipient string, t time.Time) *models.EmailResult {
+	return &models.EmailResult{EmailID: id, Status: status, Recipient: recipient, Timestamp: t.Format(time.RFC3339)}
+}
+
+func TestStoreSaveGet(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		_, err := s.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound, name)
+
+		r := result("e1", "success", "to@example.com", time.Now())
+		r.Headers = map[string]string{"User-Agent": "test"}
+		assert.NoError(t, s.Save(ctx, r), name)
+
+		got, err := s.Get(ctx, "e1")
+		assert.NoError(t, err, name)
+		assert.Equal(t, r, got, name)
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for name, s := range stores(t) {
+		assert.NoError(t, s.Save(ctx, result("old", "success", "a@example.com", base.AddDate(0, 0, -3))))
+		assert.NoError(t, s.Save(ctx, result("failed", "failure", "b@example.com", base.Add(-time.Hour))))
+		assert.NoError(t, s.Save(ctx, result("new", "success", "A@example.com", base)))
+
+		ids := func(q Query) []string {
+			results, err := s.List(ctx, q)
+			assert.NoError(t, err, name)
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.EmailID)
+			}
+			return ids
+		}
+
+		assert.Equal(t, []string{"new", "failed", "old"}, ids(Query{}), name)
+		assert.Equal(t, []string{"new", "old"}, ids(Query{Status: "success"}), name)
+		assert.Equal(t, []string{"new", "old"}, ids(Query{Recipient: "a@EXAMPLE.com"}), name)
+		assert.Equal(t, []string{"new", "failed"}, ids(Query{Since: base.AddDate(0, 0, -1)}), name)
+		assert.Equal(t, []string{"failed", "old"}, ids(Query{Until: base}), name)
+		assert.Equal(t, []string{"new"}, ids(Query{Limit: 1}), name)
+		assert.Equal(t, []string{"old", "failed"}, ids(Query{OldestFirst: true, Limit: 2}), name)
+		assert.Empty(t, ids(Query{Status: "success", Recipient: "b@example.com"}), name)
+	}
+}
+
+func TestImportFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := OpenFileStore(dir, nil)
+	base := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, files.Save(ctx, result("e1", "success", "a@example.com", base)))
+	assert.NoError(t, files.Save(ctx, result("e2", "failure", "b@example.com", base.AddDate(0, 0, 1))))
+
+	db, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "emails.db"), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A file that cannot be parsed is skipped and reported
+	badPath := filepath.Join(dir, "2024-05-10", "success", "bad.json")
+	assert.NoError(t, os.WriteFile(badPath, []byte("{not json"), 0644))
+
+	// Test importing twice gives the same records
+	for i := 0; i < 2; i++ {
+		count, skipped, err := ImportFiles(ctx, dir, db)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		if assert.Len(t, skipped, 1) {
+			assert.Equal(t, badPath, skipped[0].Path)
+			assert.ErrorContains(t, skipped[0].Err, "failed to parse email result")
+		}
+	}
+	results, err := db.List(ctx, Query{})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "e2", results[0].EmailID)
+		assert.Equal(t, "failure", results[0].Status)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	for name, s := range stores(t) {
+		assert.NoError(t, s.Save(ctx, result("old-ok", "success", "a@example.com", now.AddDate(0, 0, -10))))
+		assert.NoError(t, s.Save(ctx, result("old-failed", "failure", "a@example.com", now.AddDate(0, 0, -10))))
+		assert.NoError(t, s.Save(ctx, result("new-ok", "success", "a@example.com", now)))
+
+		removed, err := s.Prune(ctx, "success", now.AddDate(0, 0, -7))
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1, removed, name)
+
+		_, err = s.Get(ctx, "old-ok")
+		assert.ErrorIs(t, err, ErrNotFound, name)
+		for _, id := range []string{"old-failed", "new-ok"} {
+			_, err = s.Get(ctx, id)
+			assert.NoError(t, err, name+" "+id)
+		}
+	}
+}
+
+func TestStoreEncryptsAtRest(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+
+	// Test result files hold no plaintext
+	dir := t.TempDir()
+	assert.NoError(t, OpenFileStore(dir, testKeyring(t, "k1")).Save(ctx, result("e1", "success", "secret@example.com", base)))
+	data, err := os.ReadFile(filepath.Join(dir, "2024-05-10", "success", "e1.json"))
+	assert.NoError(t, err)
+	assert.True(t, encryption.IsSealed(data))
+	assert.NotContains(t, string(data), "secret")
+	_, err = OpenFileStore(dir, nil).Get(ctx, "e1")
+	assert.ErrorIs(t, err, encryption.ErrNoKeys)
+
+	// Test the database holds no plaintext either, recipient included
+	path := filepath.Join(t.TempDir(), "emails.db")
+	plain, err := OpenSQLiteStore(path, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, plain.Save(ctx, result("plain", "success", "secret@example.com", base)))
+	plain.Close()
+	db, err := OpenSQLiteStore(path, testKeyring(t, "k1"))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Save(ctx, result("k1", "success", "secret@example.com", base.Add(time.Minute))))
+	db.Close()
+
+	raw, err := OpenSQLiteStore(path, nil)
+	assert.NoError(t, err)
+	var recipient string
+	var stored []byte
+	assert.NoError(t, raw.db.QueryRow(`SELECT recipient, data FROM email_results WHERE email_id = 'k1'`).Scan(&recipient, &stored))
+	assert.NotContains(t, recipient, "secret")
+	assert.NotContains(t, string(stored), "secret")
+	raw.Close()
+
+	// Test after rotating to k2, records written in plaintext, with k1 and
+	// with k2 are all found by recipient
+	db, err = OpenSQLiteStore(path, testKeyring(t, "k2"))
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.Save(ctx, result("k2", "success", "secret@example.com", base.Add(2*time.Minute))))
+	results, err := db.List(ctx, Query{Recipient: "SECRET@example.com"})
+	assert.NoError(t, err)
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.EmailID)
+	}
+	assert.Equal(t, []string{"k2", "k1", "plain"}, ids)
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hnrobert/smtogo/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	// A local collector accepting OTLP/HTTP
+	received := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/v1/traces" {
+			received <- body
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	cfg := config.Tracing{Endpoint: collector.URL, ServiceName: "smtogo-test"}
+	shutdown, err := Setup(context.Background(), cfg)
+	assert.NoError(t, err)
+
+	_, span := Start(context.Background(), "test span")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	select {
+	case body := <-received:
+		assert.Contains(t, string(body), "test span")
+		assert.Contains(t, string(body), "smtogo-test")
+	default:
+		t.Fatal("collector received no spans")
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.Tracing{})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	ctx := WithTraceParent(context.Background(), traceParent)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(ctx))
+	assert.Equal(t, traceParent, TraceParent(ctx))
+
+	// Test empty and malformed values are ignored
+	assert.Equal(t, "", TraceID(WithTraceParent(context.Background(), "")))
+	assert.Equal(t, "", TraceID(WithTraceParent(context.Background(), "garbage")))
+	assert.Equal(t, "", TraceParent(context.Background()))
+
+	h := http.Header{}
+	h.Set("Traceparent", traceParent)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(Extract(context.Background(), h)))
+}
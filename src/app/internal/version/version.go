@@ -0,0 +1,7 @@
+// Package version holds the build version, injected at compile time with
+//
+//	go build -ldflags "-X github.com/hnrobert/smtogo/internal/version.Version=v1.2.3"
+package version
+
+// Version is the release this binary was built from, or "dev"
+var Version = "dev"